@@ -3,12 +3,17 @@ package cli
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 type Command func([]string) error
 
+// GlobalFlag handles the value of a flag that comes before the command name, such as -C <dir>
+type GlobalFlag func(string) error
+
 var root Command
 var commands = map[string]Command{}
+var globals = map[string]GlobalFlag{}
 
 // Setup sets up the CLI with a root command and subcommands
 func Setup(rootCmd Command, cmds map[string]Command) {
@@ -16,17 +21,27 @@ func Setup(rootCmd Command, cmds map[string]Command) {
 	commands = cmds
 }
 
+// SetupGlobals sets up the flags that can come before any command, keyed by their name without a dash
+func SetupGlobals(flags map[string]GlobalFlag) {
+	globals = flags
+}
+
 // Run runs the CLI with super barebones arg parsing
 func Run() error {
-	args := os.Args[1:]
+	args, err := handleGlobals(os.Args[1:])
+	if err != nil {
+		return err
+	}
+
 	var cmd Command
 	var ok bool
 
-	switch len(os.Args) {
-	case 1:
+	switch {
+	case len(args) == 0 || strings.HasPrefix(args[0], "-") || strings.Contains(args[0], "="):
+		// flags and VAR=value args without a command are the root command's
 		cmd = root
 	default:
-		cmdName := os.Args[1]
+		cmdName := args[0]
 		cmd, ok = commands[cmdName]
 		if !ok {
 			return fmt.Errorf("not a valid command: %s", cmdName)
@@ -42,3 +57,38 @@ func Run() error {
 
 	return nil
 }
+
+// handleGlobals handles the global flags at the start of args (as `-C dir` or `-C=dir`), returning the rest
+func handleGlobals(args []string) ([]string, error) {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		name := strings.TrimLeft(args[0], "-")
+		value := ""
+		hasValue := false
+
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value, hasValue = name[:eq], name[eq+1:], true
+		}
+
+		handler, ok := globals[name]
+		if !ok {
+			break
+		}
+
+		args = args[1:]
+
+		if !hasValue {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("missing value for -%s", name)
+			}
+
+			value = args[0]
+			args = args[1:]
+		}
+
+		if err := handler(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return args, nil
+}
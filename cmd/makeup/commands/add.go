@@ -1,32 +1,28 @@
 package commands
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/cohix/makeup/pkg/makefile"
+	"github.com/cohix/makeup/pkg/templates"
 	"github.com/pkg/errors"
 )
 
-const tmpl = `
-build:
-	go build -o ${BIN_DEST}
-
-run:
-	${BIN_DEST}
-
-test:
-	go test -v ./...
-
-env:
-	echo "CONFIG_KEY=some_config_val"
+// Add creates a new component from a template and includes it in main.mk
+func Add(args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	templateName := fs.String("template", templates.Default, "the template to create the component's .mk file from")
+	force := fs.Bool("force", false, "overwrite the component's .mk file if it already exists")
 
-clean:
-	rm ${BIN_DEST}
-`
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "failed to parseFlags")
+	}
 
-func Add(args []string) error {
 	if len(args) < 1 {
 		return errors.New("missing arg: component name")
 	}
@@ -40,54 +36,84 @@ func Add(args []string) error {
 		return errors.Wrap(err, "failed to Getwd")
 	}
 
-	componentDir := filepath.Join(wd, componentArg)
+	mainMkFilepath, err := findMainMk()
+	if err != nil {
+		return errors.Wrap(err, "failed to findMainMk")
+	}
 
-	_, err = os.Stat(componentDir)
+	// without an existing project, one is created in the current directory
+	if mainMkFilepath == "" {
+		mainMkFilepath = filepath.Join(wd, mainMkName)
+	}
+
+	root := filepath.Dir(mainMkFilepath)
+
+	mainmk, err := loadOrCreateMainMk(mainMkFilepath)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			if mkErr := os.MkdirAll(componentDir, os.ModePerm); mkErr != nil {
-				return errors.Wrapf(err, "failed to create component dir %s", componentDir)
-			}
-		} else {
-			return errors.Wrapf(err, "failed to os.Stat %s", componentDir)
-		}
+		return errors.Wrap(err, "failed to loadOrCreateMainMk")
 	}
 
+	componentDir := filepath.Join(wd, componentArg)
+
 	filename := fmt.Sprintf("%s.mk", componentName)
 	componentMkFilepath := filepath.Join(componentDir, filename)
 
-	if err := os.WriteFile(componentMkFilepath, []byte(tmpl), os.ModePerm); err != nil {
-		return errors.Wrapf(err, "failed to os.WriteFile %s", componentMkFilepath)
+	if mainmk.FindComponentDirective(componentName) != nil {
+		return fmt.Errorf("component %s is already declared in main.mk", componentName)
+	}
+
+	incl, i := mainmk.FindComponent(componentName)
+	if incl != nil {
+		// --force only overwrites the component's own .mk file, rather than replacing another component with the same name
+		if path := filepath.Join(root, incl.Paths[i].Path); path != componentMkFilepath {
+			return fmt.Errorf("component %s is already included in main.mk from %s", componentName, incl.Paths[i].Path)
+		}
+
+		if !*force {
+			return fmt.Errorf("component %s is already included in main.mk", componentName)
+		}
+	}
+
+	if _, err := os.Stat(componentMkFilepath); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", componentMkFilepath)
 	}
 
-	relativeComponentMkFilepath, err := filepath.Rel(wd, componentMkFilepath)
+	relativeComponentMkFilepath, err := filepath.Rel(root, componentMkFilepath)
 	if err != nil {
 		return errors.Wrap(err, "failed to filepath.Rel")
 	}
 
-	includeStatement := fmt.Sprintf("\ninclude ./%s", relativeComponentMkFilepath)
+	vars := templates.Vars{
+		Name:   componentName,
+		Dir:    filepath.Dir(relativeComponentMkFilepath),
+		MkPath: fmt.Sprintf("./%s", relativeComponentMkFilepath),
+	}
 
-	mainMkFilepath := filepath.Join(wd, "main.mk")
+	contents, err := templates.Render(*templateName, templates.Dirs(root), vars)
+	if err != nil {
+		return errors.Wrap(err, "failed to Render template")
+	}
 
-	_, err = os.Stat(mainMkFilepath)
+	_, err = os.Stat(componentDir)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			if err := os.WriteFile(mainMkFilepath, []byte(includeStatement), os.ModePerm); err != nil {
-				return errors.Wrapf(err, "failed to os.WriteFile (new) %s", mainMkFilepath)
+			if mkErr := os.MkdirAll(componentDir, 0755); mkErr != nil {
+				return errors.Wrapf(mkErr, "failed to create component dir %s", componentDir)
 			}
 		} else {
-			return errors.Wrapf(err, "failed to os.Stat %s", mainMkFilepath)
-		}
-	} else {
-		mkFile, err := os.OpenFile(mainMkFilepath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.ModePerm)
-		if err != nil {
-			return errors.Wrapf(err, "failed to os.OpenFile %s", mainMkFilepath)
+			return errors.Wrapf(err, "failed to os.Stat %s", componentDir)
 		}
+	}
 
-		defer mkFile.Close()
+	if err := os.WriteFile(componentMkFilepath, contents, 0644); err != nil {
+		return errors.Wrapf(err, "failed to os.WriteFile %s", componentMkFilepath)
+	}
 
-		if _, err = mkFile.WriteString(includeStatement); err != nil {
-			return errors.Wrapf(err, "failed to WriteString %s", mainMkFilepath)
+	if incl == nil {
+		mainmk.AppendInclude(vars.MkPath)
+
+		if err := mainmk.Write(); err != nil {
+			return errors.Wrap(err, "failed to Write main.mk")
 		}
 	}
 
@@ -95,3 +121,22 @@ func Add(args []string) error {
 
 	return nil
 }
+
+// loadOrCreateMainMk parses main.mk for editing, or creates an empty one if it does not exist yet
+func loadOrCreateMainMk(path string) (*makefile.File, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return makefile.NewFile(path), nil
+		}
+
+		return nil, errors.Wrapf(err, "failed to os.Stat %s", path)
+	}
+
+	mainmk, err := makefile.ParseAST(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to ParseAST %s", path)
+	}
+
+	return mainmk, nil
+}
@@ -1,22 +1,34 @@
 package commands
 
 import (
-	"github.com/cohix/makeup/pkg/makefile"
+	"flag"
+
 	"github.com/pkg/errors"
 )
 
 // Build builds every component of the project
 func Build(args []string) error {
-	mainmk, err := makefile.Parse("./main.mk")
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	project := addProjectFlags(fs, true)
+
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "failed to parseFlags")
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	mainmk, err := project.load(ctx, args)
 	if err != nil {
-		return errors.Wrap(err, "failed to Parse main.mk")
+		return errors.Wrap(err, "failed to load project")
 	}
 
-	if err := mainmk.TestChecks(); err != nil {
+	if err := mainmk.TestChecks(ctx); err != nil {
 		return errors.Wrap(err, "failed to TestChecks")
 	}
 
-	if err := mainmk.BuildAll(); err != nil {
+	if err := mainmk.BuildAll(ctx); err != nil {
 		return errors.Wrap(err, "failed to BuildAll")
 	}
 
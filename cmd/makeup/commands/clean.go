@@ -1,22 +1,34 @@
 package commands
 
 import (
-	"github.com/cohix/makeup/pkg/makefile"
+	"flag"
+
 	"github.com/pkg/errors"
 )
 
 // Clean runs clean on every component of the project
 func Clean(args []string) error {
-	mainmk, err := makefile.Parse("./main.mk")
+	fs := flag.NewFlagSet("clean", flag.ContinueOnError)
+	project := addProjectFlags(fs, true)
+
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "failed to parseFlags")
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	mainmk, err := project.load(ctx, args)
 	if err != nil {
-		return errors.Wrap(err, "failed to Parse main.mk")
+		return errors.Wrap(err, "failed to load project")
 	}
 
-	if err := mainmk.TestChecks(); err != nil {
+	if err := mainmk.TestChecks(ctx); err != nil {
 		return errors.Wrap(err, "failed to TestChecks")
 	}
 
-	if err := mainmk.CleanAll(); err != nil {
+	if err := mainmk.CleanAll(ctx); err != nil {
 		return errors.Wrap(err, "failed to CleanAll")
 	}
 
@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Env prints the environment that components run with, or with --diff, how it differs from makeup's own
+func Env(args []string) error {
+	fs := flag.NewFlagSet("env", flag.ContinueOnError)
+	project := addProjectFlags(fs, true)
+	diff := fs.Bool("diff", false, "show how each component's environment differs from the one makeup is running in")
+
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "failed to parseFlags")
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	mainmk, err := project.load(ctx, args)
+	if err != nil {
+		return errors.Wrap(err, "failed to load project")
+	}
+
+	components := mainmk.Components
+
+	for i, c := range components {
+		if len(components) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+
+			fmt.Printf("# %s\n", c.Name)
+		}
+
+		var lines []string
+
+		if *diff {
+			lines, err = mainmk.EnvDiff(ctx, c)
+		} else {
+			lines, err = mainmk.Environ(ctx, c)
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "failed to get env of %s", c.Name)
+		}
+
+		for _, l := range lines {
+			fmt.Println(l)
+		}
+	}
+
+	return nil
+}
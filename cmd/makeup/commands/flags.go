@@ -0,0 +1,26 @@
+package commands
+
+import (
+	"flag"
+)
+
+// parseFlags parses flags that may appear before, between or after positional args, returning the positional args
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	return positional, nil
+}
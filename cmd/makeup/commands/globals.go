@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// mainMkName is the name of the file that defines a project
+const mainMkName = "main.mk"
+
+// mainMkFile is the project file chosen with -f, which otherwise is found by searching upward for main.mk
+var mainMkFile string
+
+// ChangeDir handles the -C global flag, changing to the directory before doing anything else
+func ChangeDir(dir string) error {
+	if err := os.Chdir(dir); err != nil {
+		return errors.Wrapf(err, "failed to Chdir %s", dir)
+	}
+
+	return nil
+}
+
+// SetMainFile handles the -f global flag, which sets the project file to use instead of main.mk
+func SetMainFile(path string) error {
+	mainMkFile = path
+
+	return nil
+}
+
+// findMainMk returns the absolute path of the project file, which is the one chosen with -f or otherwise
+// the main.mk in the current directory or the closest of its parents, or "" if there is none
+func findMainMk() (string, error) {
+	if mainMkFile != "" {
+		path, err := filepath.Abs(mainMkFile)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to filepath.Abs")
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			return "", errors.Wrapf(err, "failed to Stat %s", mainMkFile)
+		}
+
+		return path, nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to Getwd")
+	}
+
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		path := filepath.Join(dir, mainMkName)
+
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	return "", nil
+}
+
+// enterProject finds the project file and changes to the directory containing it, so that the paths
+// within it resolve the same way wherever makeup is run from. It returns the project file's path
+// relative to its directory, along with the directory makeup was run from.
+func enterProject() (string, string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to Getwd")
+	}
+
+	path, err := findMainMk()
+	if err != nil {
+		return "", "", err
+	}
+
+	if path == "" {
+		return "", "", fmt.Errorf("no %s found in %s or any of its parent directories", mainMkName, wd)
+	}
+
+	if err := os.Chdir(filepath.Dir(path)); err != nil {
+		return "", "", errors.Wrapf(err, "failed to Chdir %s", filepath.Dir(path))
+	}
+
+	return "./" + filepath.Base(path), wd, nil
+}
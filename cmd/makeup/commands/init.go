@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cohix/makeup/pkg/makefile"
+	"github.com/cohix/makeup/pkg/scan"
+	"github.com/cohix/makeup/pkg/templates"
+	"github.com/pkg/errors"
+)
+
+// Init scans the project for components and generates main.mk and a .mk file for each of them
+func Init(args []string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return errors.Wrap(err, "failed to Getwd")
+	}
+
+	mainMkFilepath := filepath.Join(wd, mainMkName)
+	if mainMkFile != "" {
+		if mainMkFilepath, err = filepath.Abs(mainMkFile); err != nil {
+			return errors.Wrap(err, "failed to filepath.Abs")
+		}
+	}
+
+	if _, err := os.Stat(mainMkFilepath); err == nil {
+		return fmt.Errorf("%s already exists", mainMkFilepath)
+	}
+
+	candidates, err := scan.Detect(wd)
+	if err != nil {
+		return errors.Wrap(err, "failed to Detect components")
+	}
+
+	if len(candidates) == 0 {
+		return errors.New("no components found, use makeup add to create one")
+	}
+
+	mainmk := makefile.NewFile(mainMkFilepath)
+
+	toolchains := map[scan.Toolchain]bool{}
+
+	for _, c := range candidates {
+		if toolchains[c.Toolchain] {
+			continue
+		}
+
+		toolchains[c.Toolchain] = true
+
+		version, err := c.Toolchain.Version()
+		if err != nil {
+			fmt.Println("skipping version check for", c.Toolchain+":", err.Error())
+			continue
+		}
+
+		mainmk.AppendCheck(c.Toolchain.CheckCmd(), version)
+	}
+
+	names := componentNames(candidates, filepath.Base(wd))
+
+	for i, c := range candidates {
+		mkPath := filepath.Join(c.Dir, fmt.Sprintf("%s.mk", names[i]))
+
+		if _, err := os.Stat(filepath.Join(wd, mkPath)); err == nil {
+			fmt.Println("using existing component:", mkPath)
+		} else {
+			vars := templates.Vars{
+				Name:   names[i],
+				Dir:    c.Dir,
+				MkPath: fmt.Sprintf("./%s", mkPath),
+			}
+
+			contents, err := templates.Render(string(c.Toolchain), templates.Dirs(wd), vars)
+			if err != nil {
+				return errors.Wrapf(err, "failed to Render template for %s", c.Dir)
+			}
+
+			if err := os.WriteFile(filepath.Join(wd, mkPath), contents, 0644); err != nil {
+				return errors.Wrapf(err, "failed to os.WriteFile %s", mkPath)
+			}
+
+			fmt.Println("component created:", mkPath)
+		}
+
+		mainmk.AppendInclude(fmt.Sprintf("./%s", mkPath))
+	}
+
+	if err := mainmk.Write(); err != nil {
+		return errors.Wrap(err, "failed to Write main.mk")
+	}
+
+	fmt.Println("project created:", mainMkFilepath)
+
+	return nil
+}
+
+// componentNames names each candidate after its directory, using the full relative path for names that would collide
+func componentNames(candidates []scan.Candidate, rootName string) []string {
+	baseName := func(dir string) string {
+		if dir == "." {
+			return strings.ToLower(rootName)
+		}
+
+		return strings.ToLower(filepath.Base(dir))
+	}
+
+	counts := map[string]int{}
+	for _, c := range candidates {
+		counts[baseName(c.Dir)]++
+	}
+
+	names := []string{}
+
+	for _, c := range candidates {
+		name := baseName(c.Dir)
+		if counts[name] > 1 && c.Dir != "." {
+			name = strings.ToLower(strings.ReplaceAll(filepath.ToSlash(c.Dir), "/", "-"))
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
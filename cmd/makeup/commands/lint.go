@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/cohix/makeup/pkg/makefile"
+	"github.com/pkg/errors"
+)
+
+// Lint validates main.mk and each of the components it includes
+func Lint(args []string) error {
+	ctx, stop := interruptContext()
+	defer stop()
+
+	mainMkPath, _, err := enterProject()
+	if err != nil {
+		return errors.Wrap(err, "failed to enterProject")
+	}
+
+	diags, err := makefile.Lint(ctx, mainMkPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to Lint main.mk")
+	}
+
+	errCount := 0
+
+	for _, d := range diags {
+		fmt.Println(d.String())
+
+		if d.Severity == makefile.SeverityError {
+			errCount++
+		}
+	}
+
+	if errCount > 0 {
+		return fmt.Errorf("lint found %d error(s)", errCount)
+	}
+
+	fmt.Println("lint complete: no errors found")
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/cohix/makeup/pkg/makefile"
+	"github.com/pkg/errors"
+)
+
+const (
+	makeNative = "native"
+	makeSystem = "system"
+)
+
+// projectFlags are the flags shared by the commands that run the project's components
+type projectFlags struct {
+	make          *string
+	jobs          *int
+	makeKeepGoing *bool
+	debug         *optionalValue
+	profile       *string
+	// keepGoing and failFast choose what happens to the other components when one fails
+	keepGoing *bool
+	failFast  *bool
+	// all is only set for commands that can run a selection of components
+	all *bool
+	// parallel is set by commands that run -j components at once, rather than passing -j to make
+	parallel bool
+	// integration is set by `makeup test --integration`, which also runs main.mk's integration target
+	integration bool
+}
+
+// optionalValue is a flag that can be passed alone (--debug) or with a value (--debug=b)
+type optionalValue struct {
+	set   bool
+	value string
+}
+
+func (o *optionalValue) String() string {
+	return o.value
+}
+
+func (o *optionalValue) Set(value string) error {
+	o.set = true
+
+	// passing the flag alone sets it to "true"
+	if value != "true" {
+		o.value = value
+	}
+
+	return nil
+}
+
+func (o *optionalValue) IsBoolFlag() bool {
+	return true
+}
+
+// addProjectFlags adds the shared project flags to the FlagSet. Selectable commands accept component
+// names as args, and otherwise use the component that makeup is run from within.
+func addProjectFlags(fs *flag.FlagSet, selectable bool) *projectFlags {
+	p := &projectFlags{
+		make:          fs.String("make", makeSystem, "how to run Makefiles: native (built-in interpreter), system (the make binary, or $MAKE) or the name of a make binary such as gmake"),
+		jobs:          fs.Int("j", 0, "passed to make as -j"),
+		makeKeepGoing: fs.Bool("k", false, "passed to make as -k"),
+		debug:         &optionalValue{},
+		profile:       fs.String("profile", makefile.DefaultProfile, "passed to components as MAKEUP_PROFILE"),
+		keepGoing:     fs.Bool("keep-going", false, "carry on with the other components when one fails"),
+		failFast:      fs.Bool("fail-fast", false, "stop at the first component that fails, or when one exits while running"),
+	}
+
+	fs.Var(p.debug, "debug", "passed to make as --debug")
+
+	if selectable {
+		p.all = fs.Bool("all", false, "use every component, even when makeup is run from within one")
+	}
+
+	return p
+}
+
+// interruptContext returns a context that is cancelled when makeup is interrupted, which stops any running commands
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// load finds and parses main.mk, and configures it according to the flags and args. VAR=value args are
+// passed to make, and the others select components.
+func (p *projectFlags) load(ctx context.Context, args []string) (*makefile.Makefile, error) {
+	mainMkPath, wd, err := enterProject()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to enterProject")
+	}
+
+	mainmk, err := makefile.Parse(mainMkPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to Parse %s", mainMkPath)
+	}
+
+	switch *p.make {
+	case makeNative:
+		mainmk.Make.Native = true
+	case makeSystem:
+	case "":
+		return nil, fmt.Errorf("invalid value for --make: must be %s, %s or a make binary", makeNative, makeSystem)
+	default:
+		mainmk.Make.Bin = *p.make
+	}
+
+	mainmk.Profile = *p.profile
+
+	if *p.keepGoing && *p.failFast {
+		return nil, errors.New("only one of --keep-going and --fail-fast can be used")
+	}
+
+	mainmk.KeepGoing = *p.keepGoing
+	mainmk.FailFast = *p.failFast
+
+	names := []string{}
+
+	for _, a := range args {
+		if strings.Contains(a, "=") {
+			mainmk.Make.Args = append(mainmk.Make.Args, a)
+		} else if p.all != nil {
+			names = append(names, a)
+		} else {
+			return nil, fmt.Errorf("unexpected arg %s: only VAR=value args are accepted", a)
+		}
+	}
+
+	if p.all != nil && !*p.all {
+		if err := selectComponents(mainmk, names, wd); err != nil {
+			return nil, err
+		}
+	}
+
+	if *p.jobs > 0 && p.parallel {
+		mainmk.Jobs = *p.jobs
+	} else if *p.jobs > 0 {
+		mainmk.Make.Args = append(mainmk.Make.Args, "-j", strconv.Itoa(*p.jobs))
+	}
+
+	if *p.makeKeepGoing {
+		mainmk.Make.Args = append(mainmk.Make.Args, "-k")
+	}
+
+	if p.debug.set {
+		debug := "--debug"
+		if p.debug.value != "" {
+			debug = "--debug=" + p.debug.value
+		}
+
+		mainmk.Make.Args = append(mainmk.Make.Args, debug)
+	}
+
+	// the native interpreter doesn't need make to be installed, and neither do projects that don't use it
+	if !mainmk.Make.Native && mainmk.UsesMake(p.integration) {
+		if err := mainmk.Make.Verify(ctx); err != nil {
+			return nil, errors.Wrap(err, "failed to Verify make")
+		}
+	}
+
+	return mainmk, nil
+}
+
+// selectComponents narrows the project down to the named components, or if there are none, to the
+// component whose directory contains wd
+func selectComponents(mainmk *makefile.Makefile, names []string, wd string) error {
+	if len(names) > 0 {
+		selected := []*makefile.Component{}
+
+		for _, name := range names {
+			c := mainmk.Component(name)
+			if c == nil {
+				return fmt.Errorf("component %s is not included in %s", name, filepath.Base(mainmk.FullPath))
+			}
+
+			selected = append(selected, c)
+		}
+
+		mainmk.Components = selected
+
+		return nil
+	}
+
+	current := currentComponents(mainmk, wd)
+	if len(current) == 0 {
+		return nil
+	}
+
+	for _, c := range current {
+		fmt.Fprintln(os.Stderr, "using component:", c.Name, "(use --all for every component)")
+	}
+
+	mainmk.Components = current
+
+	return nil
+}
+
+// currentComponents returns the components in the directory closest to wd that contains it, other
+// than the project root (components such as Procfile entries can share a directory)
+func currentComponents(mainmk *makefile.Makefile, wd string) []*makefile.Component {
+	root := mainmk.Root()
+
+	closest := ""
+	current := []*makefile.Component{}
+
+	for _, c := range mainmk.Components {
+		dir := c.Dir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+
+		if dir == root || (wd != dir && !strings.HasPrefix(wd, dir+string(filepath.Separator))) {
+			continue
+		}
+
+		if len(dir) > len(closest) {
+			closest = dir
+			current = []*makefile.Component{}
+		}
+
+		if dir == closest {
+			current = append(current, c)
+		}
+	}
+
+	return current
+}
@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/cohix/makeup/pkg/makefile"
+	"github.com/pkg/errors"
+)
+
+// Remove removes a component (and any overrides of its targets) from main.mk, leaving its files in place
+func Remove(args []string) error {
+	if len(args) < 1 {
+		return errors.New("missing arg: component name")
+	}
+
+	componentName := args[0]
+
+	mainMkPath, _, err := enterProject()
+	if err != nil {
+		return errors.Wrap(err, "failed to enterProject")
+	}
+
+	mainmk, err := makefile.ParseAST(mainMkPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to ParseAST main.mk")
+	}
+
+	var componentPath string
+
+	if incl, i := mainmk.FindComponent(componentName); incl != nil {
+		componentPath = incl.Paths[i].Path
+		mainmk.RemoveIncludePath(incl, i)
+	} else if directive := mainmk.FindComponentDirective(componentName); directive != nil {
+		componentPath = directive.Value
+
+		for _, m := range mainmk.Modifiers(directive) {
+			mainmk.Remove(m)
+		}
+
+		mainmk.Remove(directive)
+	} else {
+		return fmt.Errorf("component %s is not included in main.mk", componentName)
+	}
+
+	for _, o := range mainmk.Overrides(componentName) {
+		mainmk.RemoveOverride(o)
+	}
+
+	if err := mainmk.Write(); err != nil {
+		return errors.Wrap(err, "failed to Write main.mk")
+	}
+
+	fmt.Println("component removed:", componentName)
+	fmt.Println(componentPath, "was left in place")
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cohix/makeup/pkg/makefile"
+	"github.com/pkg/errors"
+)
+
+// Rename renames a component's .mk file and updates its include and overrides in main.mk
+func Rename(args []string) error {
+	if len(args) < 2 {
+		return errors.New("missing args: old and new component names")
+	}
+
+	oldName, newName := args[0], strings.ToLower(args[1])
+
+	mainMkPath, _, err := enterProject()
+	if err != nil {
+		return errors.Wrap(err, "failed to enterProject")
+	}
+
+	mainmk, err := makefile.ParseAST(mainMkPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to ParseAST main.mk")
+	}
+
+	if existing, _ := mainmk.FindComponent(newName); existing != nil || mainmk.FindComponentDirective(newName) != nil {
+		return fmt.Errorf("component %s is already included in main.mk", newName)
+	}
+
+	var declaration makefile.Node
+
+	incl, i := mainmk.FindComponent(oldName)
+	if incl != nil {
+		declaration = incl
+	} else if directive := mainmk.FindComponentDirective(oldName); directive != nil {
+		declaration = directive
+	}
+
+	// components named with a `#makeup: name` line are renamed by changing it, leaving their files alone
+	if declaration != nil && mainmk.NameDirective(declaration) != nil {
+		mainmk.SetDirectiveValue(mainmk.NameDirective(declaration), newName)
+
+		return writeRename(mainmk, oldName, newName)
+	}
+
+	if incl == nil {
+		if mainmk.FindComponentDirective(oldName) != nil {
+			return fmt.Errorf("component %s is named after its directory and has no .mk file to rename", oldName)
+		}
+
+		return fmt.Errorf("component %s is not included in main.mk", oldName)
+	}
+
+	oldPath := incl.Paths[i].Path
+	newPath := filepath.Join(filepath.Dir(oldPath), fmt.Sprintf("%s.mk", newName))
+
+	if strings.HasPrefix(oldPath, "./") {
+		newPath = "./" + newPath
+	}
+
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("%s already exists", newPath)
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return errors.Wrapf(err, "failed to os.Rename %s", oldPath)
+	}
+
+	mainmk.SetIncludePath(incl, i, newPath)
+
+	return writeRename(mainmk, oldName, newName)
+}
+
+// writeRename renames the component's overrides and the `#makeup: after` lines that refer to it, and writes main.mk
+func writeRename(mainmk *makefile.File, oldName, newName string) error {
+	mainmk.RenameAfter(oldName, newName)
+
+	for _, o := range mainmk.Overrides(oldName) {
+		target := strings.TrimPrefix(o.Targets[0], oldName+"/")
+		mainmk.RenameTarget(o, fmt.Sprintf("%s/%s", newName, target))
+	}
+
+	if err := mainmk.Write(); err != nil {
+		return errors.Wrap(err, "failed to Write main.mk")
+	}
+
+	fmt.Println("component renamed:", oldName, "->", newName)
+
+	return nil
+}
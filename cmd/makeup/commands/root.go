@@ -1,26 +1,38 @@
 package commands
 
 import (
-	"github.com/cohix/makeup/pkg/makefile"
+	"flag"
+
 	"github.com/pkg/errors"
 )
 
 // Root is the root command
 func Root(args []string) error {
-	mainmk, err := makefile.Parse("./main.mk")
+	fs := flag.NewFlagSet("makeup", flag.ContinueOnError)
+	project := addProjectFlags(fs, false)
+
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "failed to parseFlags")
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	mainmk, err := project.load(ctx, args)
 	if err != nil {
-		return errors.Wrap(err, "failed to Parse main.mk")
+		return errors.Wrap(err, "failed to load project")
 	}
 
-	if err := mainmk.TestChecks(); err != nil {
+	if err := mainmk.TestChecks(ctx); err != nil {
 		return errors.Wrap(err, "failed to TestChecks")
 	}
 
-	if err := mainmk.BuildAll(); err != nil {
+	if err := mainmk.BuildAll(ctx); err != nil {
 		return errors.Wrap(err, "failed to BuildAll")
 	}
 
-	if err := mainmk.RunAll(); err != nil {
+	if err := mainmk.RunAll(ctx); err != nil {
 		return errors.Wrap(err, "failed to RunAll")
 	}
 
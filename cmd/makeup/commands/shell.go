@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/cohix/makeup/pkg/exec"
+	"github.com/pkg/errors"
+)
+
+// Shell opens a subshell with the project's environment, or with a component's if one is named
+func Shell(args []string) error {
+	fs := flag.NewFlagSet("shell", flag.ContinueOnError)
+	project := addProjectFlags(fs, false)
+
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "failed to parseFlags")
+	}
+
+	component := ""
+	if len(args) > 0 && !strings.Contains(args[0], "=") {
+		component, args = args[0], args[1:]
+	}
+
+	ctx := context.Background()
+
+	mainmk, err := project.load(ctx, args)
+	if err != nil {
+		return errors.Wrap(err, "failed to load project")
+	}
+
+	env := mainmk.ProjectEnv()
+	hermetic := false
+
+	if component != "" {
+		c := mainmk.Component(component)
+		if c == nil {
+			return fmt.Errorf("component %s is not included in main.mk", component)
+		}
+
+		if env, err = mainmk.Environ(ctx, c); err != nil {
+			return errors.Wrapf(err, "failed to get env of %s", c.Name)
+		}
+
+		// Environ is the component's whole environment
+		hermetic = true
+	}
+
+	program := os.Getenv("SHELL")
+	if program == "" {
+		program = "sh"
+	}
+
+	// the shell shares makeup's terminal, so interrupts are left for it to handle
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	cmd := exec.New(program)
+	cmd.Env = env
+	cmd.Hermetic = hermetic
+	cmd.Interactive = true
+	cmd.ProcessGroup = false
+
+	fmt.Println("starting a shell with the project's environment, exit it to return")
+
+	// the shell's exit status is that of the last command run in it, so only failing to start it is an error
+	if _, err := cmd.Run(ctx); err != nil && exec.ExitCode(err) < 0 {
+		return errors.Wrapf(err, "failed to Run %s", program)
+	}
+
+	return nil
+}
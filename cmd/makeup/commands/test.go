@@ -1,23 +1,193 @@
 package commands
 
 import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/cohix/makeup/pkg/makefile"
 	"github.com/pkg/errors"
 )
 
+const (
+	reportJUnit = "junit"
+	reportJSON  = "json"
+)
+
+// reportWriters write each kind of report
+var reportWriters = map[string]func(path string, results []*makefile.TestResult) error{
+	reportJUnit: makefile.WriteJUnit,
+	reportJSON:  makefile.WriteJSON,
+}
+
+// reportFlag is a list of reports to write, each given as --report <kind>=<path>
+type reportFlag []report
+
+type report struct {
+	kind string
+	path string
+}
+
+func (r *reportFlag) String() string {
+	reports := []string{}
+	for _, rep := range *r {
+		reports = append(reports, rep.kind+"="+rep.path)
+	}
+
+	return strings.Join(reports, ",")
+}
+
+func (r *reportFlag) Set(value string) error {
+	kind, path, ok := strings.Cut(value, "=")
+	if !ok || path == "" {
+		return fmt.Errorf("report must be given as <kind>=<path>, such as %s=out.xml", reportJUnit)
+	}
+
+	if _, ok := reportWriters[kind]; !ok {
+		return fmt.Errorf("unknown report kind %s, must be %s or %s", kind, reportJUnit, reportJSON)
+	}
+
+	// makeup moves to the project's root before running, so paths are made relative to where it started
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to filepath.Abs")
+	}
+
+	*r = append(*r, report{kind: kind, path: abs})
+
+	return nil
+}
+
+// shardFlag is the part of the project to test, given as --shard <index>/<count>
+type shardFlag struct {
+	index int
+	count int
+}
+
+func (s *shardFlag) String() string {
+	if s.count == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d/%d", s.index, s.count)
+}
+
+func (s *shardFlag) Set(value string) error {
+	index, count, ok := strings.Cut(value, "/")
+
+	i, indexErr := strconv.Atoi(index)
+	n, countErr := strconv.Atoi(count)
+
+	if !ok || indexErr != nil || countErr != nil || n < 1 || i < 1 || i > n {
+		return fmt.Errorf("shard must be given as <index>/<count> with index from 1 to count, such as 1/4")
+	}
+
+	s.index, s.count = i, n
+
+	return nil
+}
+
 // Test runs a test on every component of the project
 func Test(args []string) error {
-	mainmk, err := makefile.Parse("./main.mk")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	project := addProjectFlags(fs, true)
+	project.parallel = true
+	fs.Lookup("j").Usage = "how many components to test at once"
+
+	noCache := fs.Bool("no-cache", false, "test every component, even those whose tests passed and haven't changed since")
+	cover := fs.Bool("cover", false, "give each component somewhere to write Go coverage, and merge it into .makeup/coverage")
+	integration := fs.Bool("integration", false, "build and run the project, and run integration targets against it instead of test targets")
+	retries := fs.Int("retries", 0, "how many more times to run a component's failed test target, reporting it as flaky if it then passes")
+	shardDurations := fs.String("shard-durations", "", "a JSON report from an earlier run, used to balance shards by how long components took")
+
+	shard := &shardFlag{}
+	fs.Var(shard, "shard", "only test shard <index>/<count> of the components, such as 1/4")
+
+	reports := &reportFlag{}
+	fs.Var(reports, "report", "write a report of the results as <kind>=<path>, where kind is junit or json")
+
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "failed to parseFlags")
+	}
+
+	if *retries < 0 {
+		return errors.New("--retries must not be negative")
+	}
+
+	// makeup moves to the project's root before running, so the path is made relative to where it started
+	durationsPath := ""
+	if *shardDurations != "" {
+		if durationsPath, err = filepath.Abs(*shardDurations); err != nil {
+			return errors.Wrap(err, "failed to filepath.Abs")
+		}
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	project.integration = *integration
+
+	mainmk, err := project.load(ctx, args)
 	if err != nil {
-		return errors.Wrap(err, "failed to Parse main.mk")
+		return errors.Wrap(err, "failed to load project")
+	}
+
+	mainmk.NoCache = *noCache
+	mainmk.Retries = *retries
+	mainmk.Cover = *cover
+
+	if shard.count > 0 {
+		var durations map[string]time.Duration
+
+		if durationsPath != "" {
+			if durations, err = makefile.ReadDurations(durationsPath); err != nil {
+				return errors.Wrap(err, "failed to ReadDurations")
+			}
+		}
+
+		if err := mainmk.Shard(shard.index, shard.count, durations); err != nil {
+			return errors.Wrap(err, "failed to Shard")
+		}
+
+		fmt.Printf("testing shard %s: %d components\n", shard, len(mainmk.Components))
 	}
 
-	if err := mainmk.TestChecks(); err != nil {
+	if err := mainmk.TestChecks(ctx); err != nil {
 		return errors.Wrap(err, "failed to TestChecks")
 	}
 
-	if err := mainmk.TestAll(); err != nil {
-		return errors.Wrap(err, "failed to TestAll")
+	var results []*makefile.TestResult
+	var testErr error
+
+	if *integration {
+		results, testErr = mainmk.TestIntegration(ctx)
+	} else {
+		results, testErr = mainmk.TestAll(ctx)
+	}
+
+	// reports are written even when tests fail, since that's when they're needed
+	for _, r := range *reports {
+		if err := reportWriters[r.kind](r.path, results); err != nil {
+			return errors.Wrapf(err, "failed to write %s report", r.kind)
+		}
+
+		fmt.Println("report written:", r.path)
+	}
+
+	if *cover {
+		fmt.Println()
+
+		if err := mainmk.MergeCoverage(ctx); err != nil {
+			return errors.Wrap(err, "failed to MergeCoverage")
+		}
+	}
+
+	if testErr != nil {
+		return errors.Wrap(testErr, "failed to test")
 	}
 
 	return nil
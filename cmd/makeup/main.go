@@ -1,26 +1,85 @@
 package main
 
 import (
+	"errors"
 	"log/slog"
 	"os"
 
 	"github.com/cohix/makeup/cmd/makeup/cli"
 	"github.com/cohix/makeup/cmd/makeup/commands"
+	"github.com/cohix/makeup/pkg/makefile"
+)
+
+// makeup exits with a different code for each kind of failure, so that scripts can tell them apart
+const (
+	exitError       = 1
+	exitInvalid     = 2
+	exitCheckFailed = 3
+	exitBuildFailed = 4
+	exitTestFailed  = 5
+	exitRunFailed   = 6
+	exitCleanFailed = 7
 )
 
 func main() {
 	cli.Setup(
 		commands.Root,
 		map[string]cli.Command{
-			"add":   commands.Add,
-			"build": commands.Build,
-			"test":  commands.Test,
-			"clean": commands.Clean,
+			"add":    commands.Add,
+			"build":  commands.Build,
+			"init":   commands.Init,
+			"test":   commands.Test,
+			"clean":  commands.Clean,
+			"env":    commands.Env,
+			"shell":  commands.Shell,
+			"lint":   commands.Lint,
+			"remove": commands.Remove,
+			"rename": commands.Rename,
+		},
+	)
+
+	cli.SetupGlobals(
+		map[string]cli.GlobalFlag{
+			"C": commands.ChangeDir,
+			"f": commands.SetMainFile,
 		},
 	)
 
 	if err := cli.Run(); err != nil {
+		// the failures have already been reported in full, so they're only named here
+		var componentsErr *makefile.ComponentsError
+		if errors.As(err, &componentsErr) {
+			err = componentsErr
+		}
+
 		slog.Error(err.Error())
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
+
+// exitCode returns the exit code for the kind of failure that err is
+func exitCode(err error) int {
+	var parseErr *makefile.ParseError
+	var checkErr *makefile.CheckError
+	var targetErr *makefile.TargetError
+
+	switch {
+	case errors.As(err, &parseErr):
+		return exitInvalid
+	case errors.As(err, &checkErr):
+		return exitCheckFailed
+	case errors.As(err, &targetErr):
+		switch targetErr.Target {
+		case "build":
+			return exitBuildFailed
+		case "test", "integration":
+			return exitTestFailed
+		case "run", "env":
+			return exitRunFailed
+		case "clean":
+			return exitCleanFailed
+		}
+	}
+
+	return exitError
+}
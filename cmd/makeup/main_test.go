@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cohix/makeup/pkg/makefile"
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "other", err: errors.New("boom"), want: exitError},
+		{name: "invalid main.mk", err: &makefile.ParseError{Msg: "bad"}, want: exitInvalid},
+		{name: "check", err: &makefile.CheckError{Err: errors.New("boom")}, want: exitCheckFailed},
+		{name: "build", err: &makefile.TargetError{Target: "build", Err: errors.New("boom")}, want: exitBuildFailed},
+		{name: "test", err: &makefile.TargetError{Target: "test", Err: errors.New("boom")}, want: exitTestFailed},
+		{name: "integration", err: &makefile.TargetError{Target: "integration", Err: errors.New("boom")}, want: exitTestFailed},
+		{name: "run", err: &makefile.TargetError{Target: "run", Err: errors.New("boom")}, want: exitRunFailed},
+		{name: "env", err: &makefile.TargetError{Target: "env", Err: errors.New("boom")}, want: exitRunFailed},
+		{name: "clean", err: &makefile.TargetError{Target: "clean", Err: errors.New("boom")}, want: exitCleanFailed},
+		{name: "wrapped", err: pkgerrors.Wrap(&makefile.TargetError{Target: "build", Err: errors.New("boom")}, "failed to build"), want: exitBuildFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,57 +2,210 @@ package exec
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// killDelay is how long a cancelled command has to exit after being interrupted before it is killed
+const killDelay = 5 * time.Second
+
+// Command is a program to run with a list of args, which are passed to it as-is rather than being
+// interpreted by a shell
+type Command struct {
+	Program string
+	Args    []string
+	// Dir is the directory to run in, defaulting to the current directory
+	Dir string
+	// Env is added to the environment that makeup is running in
+	Env []string
+	// Hermetic passes only Env to the command, rather than adding it to makeup's environment
+	Hermetic bool
+	// Stdout and Stderr receive the command's output as it runs, which is discarded if they are nil
+	Stdout io.Writer
+	Stderr io.Writer
+	// Interactive connects the command directly to makeup's terminal, in which case its output is not captured
+	Interactive bool
+	// ProcessGroup runs the command in its own process group, so that everything it starts is stopped along with it
+	ProcessGroup bool
+}
+
+// New creates a Command that runs a program with the given args in its own process group
+func New(program string, args ...string) *Command {
+	c := &Command{
+		Program:      program,
+		Args:         args,
+		ProcessGroup: true,
+	}
+
+	return c
+}
+
+// Shell creates a Command that runs cmd with sh, for commands written by the user such as checks and recipes
+func Shell(cmd string) *Command {
+	return New("sh", "-c", cmd)
+}
+
+// String returns the command line, quoting args that contain whitespace
+func (c *Command) String() string {
+	parts := []string{c.Program}
+
+	for _, a := range c.Args {
+		if strings.ContainsAny(a, " \t\n") || a == "" {
+			a = strconv.Quote(a)
+		}
+
+		parts = append(parts, a)
+	}
+
+	return strings.Join(parts, " ")
+}
+
 // Run runs a command, outputting to terminal and returning the full output and/or error.
 func Run(cmd string, out io.Writer, env ...string) (string, error) {
-	return run(cmd, "", false, out, env...)
+	return RunContext(context.Background(), cmd, out, env...)
 }
 
 // RunInDir runs a command in the specified directory and returns the full output or error.
 func RunInDir(cmd, dir string, out io.Writer, env ...string) (string, error) {
-	return run(cmd, dir, false, out, env...)
+	return RunInDirContext(context.Background(), cmd, dir, out, env...)
 }
 
 // RunSilent runs a command without printing to stdout and returns the full output or error.
 func RunSilent(cmd string, dir string, env ...string) (string, error) {
-	return run(cmd, dir, true, nil, env...)
+	return RunSilentContext(context.Background(), cmd, dir, env...)
 }
 
-func run(cmd, dir string, silent bool, out io.Writer, env ...string) (string, error) {
-	// you can uncomment this below if you want to see exactly the commands being run
-	// fmt.Println("▶️", cmd).
+// RunContext is Run, stopping the command if the context is cancelled.
+func RunContext(ctx context.Context, cmd string, out io.Writer, env ...string) (string, error) {
+	return RunInDirContext(ctx, cmd, "", out, env...)
+}
 
-	command := exec.Command("sh", "-c", cmd)
+// RunInDirContext is RunInDir, stopping the command if the context is cancelled.
+func RunInDirContext(ctx context.Context, cmd, dir string, out io.Writer, env ...string) (string, error) {
+	command := Shell(cmd)
+	command.Dir = dir
+	command.Env = env
+	command.Stdout, command.Stderr = outputs(out)
 
+	return command.Run(ctx)
+}
+
+// RunSilentContext is RunSilent, stopping the command if the context is cancelled.
+func RunSilentContext(ctx context.Context, cmd string, dir string, env ...string) (string, error) {
+	command := Shell(cmd)
 	command.Dir = dir
-	command.Env = append(os.Environ(), env...)
+	command.Env = env
+
+	return command.Run(ctx)
+}
+
+// outputs returns the writers for a command's stdout and stderr, which are the terminal's if out is nil
+func outputs(out io.Writer) (io.Writer, io.Writer) {
+	if out == nil {
+		return os.Stdout, os.Stderr
+	}
+
+	return out, out
+}
+
+// Run runs the command, returning its combined output and/or error. The command is stopped if the
+// context is cancelled.
+func (c *Command) Run(ctx context.Context) (string, error) {
+	// you can uncomment this below if you want to see exactly the commands being run
+	// fmt.Println("▶️", c.Program, c.Args).
+
+	command := exec.Command(c.Program, c.Args...)
+
+	command.Dir = c.Dir
+	command.Env = append(os.Environ(), c.Env...)
+	if c.Hermetic {
+		command.Env = append([]string{}, c.Env...)
+	}
+
+	if c.ProcessGroup {
+		setProcessGroup(command)
+	}
 
 	var outBuf bytes.Buffer
 
-	if silent {
-		command.Stdout = &outBuf
-		command.Stderr = &outBuf
-	} else if out != nil {
-		command.Stdout = io.MultiWriter(out, &outBuf)
-		command.Stderr = io.MultiWriter(out, &outBuf)
-	} else {
-		command.Stdout = io.MultiWriter(os.Stdout, &outBuf)
-		command.Stderr = io.MultiWriter(os.Stderr, &outBuf)
+	command.Stdout = &outBuf
+	command.Stderr = &outBuf
+
+	if c.Stdout != nil {
+		command.Stdout = io.MultiWriter(c.Stdout, &outBuf)
+	}
+
+	if c.Stderr != nil {
+		command.Stderr = io.MultiWriter(c.Stderr, &outBuf)
+	}
+
+	if c.Interactive {
+		command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", errors.Wrap(err, "command was not started")
+	}
+
+	if err := command.Start(); err != nil {
+		return "", errors.Wrapf(err, "failed to Start %s", c.Program)
 	}
 
-	runErr := command.Run()
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			c.stop(command, done)
+		}
+	}()
+
+	runErr := command.Wait()
 
 	outStr := outBuf.String()
 
+	if ctx.Err() != nil {
+		return outStr, errors.Wrap(ctx.Err(), "command was stopped")
+	}
+
 	if runErr != nil {
 		return outStr, errors.Wrap(runErr, "failed to Run command")
 	}
 
 	return outStr, nil
 }
+
+// stop interrupts the command, and kills it if it hasn't exited by the time killDelay passes
+func (c *Command) stop(command *exec.Cmd, done chan struct{}) {
+	if !c.ProcessGroup {
+		_ = command.Process.Kill()
+		return
+	}
+
+	interruptProcessGroup(command)
+
+	select {
+	case <-done:
+	case <-time.After(killDelay):
+		killProcessGroup(command)
+	}
+}
+
+// ExitCode returns the exit code of a command that failed, or -1 if the error isn't from it exiting
+func ExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package exec
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func interruptProcessGroup(command *exec.Cmd) {
+	// a negative pid signals the whole process group
+	_ = syscall.Kill(-command.Process.Pid, syscall.SIGTERM)
+}
+
+func killProcessGroup(command *exec.Cmd) {
+	_ = syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package exec
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(command *exec.Cmd) {}
+
+func interruptProcessGroup(command *exec.Cmd) {
+	_ = command.Process.Kill()
+}
+
+func killProcessGroup(command *exec.Cmd) {
+	_ = command.Process.Kill()
+}
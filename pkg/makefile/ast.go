@@ -0,0 +1,118 @@
+package makefile
+
+import "fmt"
+
+// Pos is a position within a parsed Makefile
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+// String formats the position as file:line:col
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// Node is a top-level element of a parsed Makefile
+type Node interface {
+	// Pos returns the position the node starts at
+	Pos() Pos
+	// Raw returns the physical source lines the node was parsed from
+	Raw() []string
+}
+
+// source holds the position and original text shared by every Node
+type source struct {
+	pos Pos
+	raw []string
+}
+
+func (s *source) Pos() Pos {
+	return s.pos
+}
+
+func (s *source) Raw() []string {
+	return s.raw
+}
+
+// Blank is an empty line
+type Blank struct {
+	source
+}
+
+// Comment is a comment that is not a makeup directive
+type Comment struct {
+	source
+	Text string
+}
+
+// Directive is a makeup directive, a comment of the form `#makeup: name value` (or `# name value` for
+// the legacy directives such as check)
+type Directive struct {
+	source
+	Name     string
+	Value    string
+	ValuePos Pos
+}
+
+// Include is an `include`, `-include` or `sinclude` statement
+type Include struct {
+	source
+	Keyword string
+	Paths   []IncludePath
+}
+
+// IncludePath is a single path listed by an Include
+type IncludePath struct {
+	Path string
+	Pos  Pos
+}
+
+// Optional returns true if make ignores the include's missing files
+func (i *Include) Optional() bool {
+	return i.Keyword != "include"
+}
+
+// Rule is a target definition along with its recipe
+type Rule struct {
+	source
+	Targets []string
+	Prereqs []string
+	Recipe  []string
+}
+
+// Assignment is a variable assignment such as `VAR := value`
+type Assignment struct {
+	source
+	Name  string
+	Op    string
+	Value string
+}
+
+// Other is any line (or define block) that makeup does not interpret
+type Other struct {
+	source
+	Text string
+}
+
+// File is the AST of a parsed Makefile
+type File struct {
+	Path     string
+	Nodes    []Node
+	Warnings []Diagnostic
+
+	// crlf and noFinalNewline preserve the original formatting when the File is written back out
+	crlf           bool
+	noFinalNewline bool
+}
+
+// ParseError is an error at a specific position of a Makefile
+type ParseError struct {
+	Pos Pos
+	Msg string
+}
+
+func (p *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", p.Pos, p.Msg)
+}
@@ -1,43 +1,43 @@
 package makefile
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
 
-	"github.com/cohix/makeup/pkg/exec"
 	"github.com/pkg/errors"
 )
 
-// BuildAll sequentially runs each of the project components' build targets
-func (m *Makefile) BuildAll() error {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return errors.Wrap(err, "failed to Getwd")
+// BuildAll sequentially runs each of the project components' build targets, stopping at the first
+// failure unless KeepGoing is set
+func (m *Makefile) BuildAll(ctx context.Context) error {
+	if err := m.runHook(ctx, "pre-build"); err != nil {
+		return err
 	}
 
-	binBase := filepath.Join(cwd, ".bin")
-
-	for _, incl := range m.Includes {
-		componentDir := filepath.Dir(incl.Path)
-		componentMakefile := filepath.Base(incl.Path)
-		componentName := strings.TrimSuffix(componentMakefile, ".mk")
+	err := m.forEach(ctx, "build", func(c *Component) error {
+		fmt.Println("building:", c.Name)
 
-		fmt.Println("building:", componentName)
-
-		binDest := filepath.Join(binBase, componentName)
+		env, err := m.targetEnv(c, "build")
+		if err != nil {
+			return errors.Wrap(err, "failed to targetEnv")
+		}
 
-		env := []string{
-			fmt.Sprintf("BIN_DEST=%s", binDest),
+		if _, err := m.runTarget(ctx, c, "build", nil, env); err != nil {
+			return errors.Wrapf(err, "failed to build %s", c.Dir)
 		}
 
-		if _, err := exec.RunInDir(fmt.Sprintf("make -s -f %s build", componentMakefile), componentDir, nil, env...); err != nil {
-			return errors.Wrapf(err, "failed to build %s", componentDir)
+		if err := m.checkArtifacts(c); err != nil {
+			return errors.Wrap(err, "failed to checkArtifacts")
 		}
 
-		fmt.Println("build complete:", componentName)
+		fmt.Println("build complete:", c.Name)
+
+		return nil
+	})
+
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return m.runHook(ctx, "post-build")
 }
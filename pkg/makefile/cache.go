@@ -0,0 +1,295 @@
+package makefile
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cohix/makeup/pkg/exec"
+)
+
+// cacheSkipDirs are directories whose contents aren't hashed for the test cache, along with hidden ones
+// such as .git and .bin
+var cacheSkipDirs = map[string]bool{
+	"node_modules": true,
+	"target":       true,
+}
+
+// cachedTest is a passing test result stored in the test cache
+type cachedTest struct {
+	Key    string
+	Result *TestResult
+}
+
+// testCacheFile returns the file that the component's cached test result is stored in
+func (m *Makefile) testCacheFile(c *Component) string {
+	return filepath.Join(m.Root(), ".makeup", "cache", "test", c.Name+".json")
+}
+
+// testCacheKey hashes everything that the component's test result depends on: the files in its
+// directory, the Go packages its tests import from elsewhere, its .mk file, main.mk, the args passed to
+// make, the env makeup gives its tests and the variables named by passenv directives. The rest of
+// makeup's environment is left out, so that unrelated shell variables don't invalidate the cache.
+func (m *Makefile) testCacheKey(ctx context.Context, c *Component, env []string) (string, error) {
+	hash := sha256.New()
+
+	passEnv := []string{}
+
+	for _, name := range append(append([]string{}, m.PassEnv...), c.PassEnv...) {
+		if value, ok := os.LookupEnv(name); ok {
+			passEnv = append(passEnv, name+"="+value)
+		}
+	}
+
+	environ := mergeEnv(passEnv, env)
+
+	sort.Strings(environ)
+
+	fmt.Fprintf(hash, "env\x00%s\x00", strings.Join(environ, "\x00"))
+	fmt.Fprintf(hash, "makeargs\x00%s\x00", strings.Join(m.Make.Args, "\x00"))
+
+	for _, file := range []string{m.FullPath, c.File} {
+		if file == "" {
+			continue
+		}
+
+		if err := hashFile(hash, file); err != nil {
+			return "", err
+		}
+	}
+
+	hasGo := false
+
+	err := filepath.WalkDir(c.Dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != c.Dir && (strings.HasPrefix(d.Name(), ".") || cacheSkipDirs[d.Name()]) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.Type()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return errors.Wrapf(err, "failed to Readlink %s", path)
+			}
+
+			fmt.Fprintf(hash, "link\x00%s\x00%s\x00", path, target)
+
+			return nil
+		}
+
+		hasGo = hasGo || strings.HasSuffix(path, ".go")
+
+		return hashFile(hash, path)
+	})
+
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to WalkDir %s", c.Dir)
+	}
+
+	if hasGo {
+		if err := m.hashGoDeps(ctx, hash, c, env); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// goPackage is the part of the output of go list -json that the test cache key needs
+type goPackage struct {
+	Dir      string
+	Standard bool
+	Module   *goModule
+
+	GoFiles, CgoFiles, CFiles, CXXFiles, HFiles, SFiles, SysoFiles         []string
+	EmbedFiles, TestGoFiles, XTestGoFiles, TestEmbedFiles, XTestEmbedFiles []string
+}
+
+type goModule struct {
+	Path    string
+	Version string
+	Main    bool
+	GoMod   string
+	Replace *goModule
+}
+
+// hashGoDeps adds the Go packages that the component's packages and tests import from outside its
+// directory to the hash. Packages in the main module or a module replaced by a local directory are
+// hashed by their files, and other modules by their version. Nothing is added if go list fails, such as
+// when Go isn't installed, in which case only the component's own directory is hashed.
+func (m *Makefile) hashGoDeps(ctx context.Context, hash io.Writer, c *Component, env []string) error {
+	var stdout bytes.Buffer
+
+	cmd := exec.New("go", "list", "-e", "-deps", "-test", "-json", "./...")
+	cmd.Dir = c.Dir
+	cmd.Env = mergeEnv(m.inherited(c), env)
+	cmd.Hermetic = true
+	cmd.Stdout = &stdout
+
+	if _, err := cmd.Run(ctx); err != nil {
+		return nil
+	}
+
+	files := map[string]bool{}
+	modules := map[string]bool{}
+
+	decoder := json.NewDecoder(&stdout)
+
+	for decoder.More() {
+		pkg := goPackage{}
+		if err := decoder.Decode(&pkg); err != nil {
+			return errors.Wrap(err, "failed to decode the output of go list")
+		}
+
+		if pkg.Standard {
+			continue
+		}
+
+		mod := pkg.Module
+		if mod != nil && mod.Replace != nil {
+			mod = mod.Replace
+		}
+
+		if mod != nil && !mod.Main && mod.Version != "" {
+			modules[mod.Path+"@"+mod.Version] = true
+			continue
+		}
+
+		if mod != nil && mod.GoMod != "" {
+			files[mod.GoMod] = true
+			files[filepath.Join(filepath.Dir(mod.GoMod), "go.sum")] = true
+		}
+
+		for _, list := range [][]string{
+			pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, pkg.HFiles, pkg.SFiles, pkg.SysoFiles,
+			pkg.EmbedFiles, pkg.TestGoFiles, pkg.XTestGoFiles, pkg.TestEmbedFiles, pkg.XTestEmbedFiles,
+		} {
+			for _, name := range list {
+				files[filepath.Join(pkg.Dir, name)] = true
+			}
+		}
+	}
+
+	for _, mod := range sortedKeys(modules) {
+		fmt.Fprintf(hash, "module\x00%s\x00", mod)
+	}
+
+	dir, err := filepath.Abs(c.Dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to Abs %s", c.Dir)
+	}
+
+	for _, file := range sortedKeys(files) {
+		// the component's own files have already been hashed
+		if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			continue
+		}
+
+		if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err := hashFile(hash, file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys of the set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := []string{}
+
+	for key := range set {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// hashFile adds the file's path and contents to the hash
+func hashFile(hash io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to Open %s", path)
+	}
+
+	defer file.Close()
+
+	fmt.Fprintf(hash, "file\x00%s\x00", filepath.ToSlash(path))
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+
+	return nil
+}
+
+// cachedTestResult returns the component's cached result if it was stored with the same key, or nil
+func (m *Makefile) cachedTestResult(c *Component, key string) *TestResult {
+	data, err := os.ReadFile(m.testCacheFile(c))
+	if err != nil {
+		return nil
+	}
+
+	cached := cachedTest{}
+
+	// an unreadable cache file is treated as a miss, and is replaced once the tests pass
+	if err := json.Unmarshal(data, &cached); err != nil || cached.Key != key || cached.Result == nil {
+		return nil
+	}
+
+	cached.Result.Cached = true
+	cached.Result.Attempts = 0
+	cached.Result.Flaky = false
+
+	return cached.Result
+}
+
+// cacheTestResult stores a passing result for the component, or removes the cached one if it failed
+func (m *Makefile) cacheTestResult(c *Component, key string, result *TestResult) error {
+	path := m.testCacheFile(c)
+
+	if result.Status != TestPassed {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrapf(err, "failed to Remove %s", path)
+		}
+
+		return nil
+	}
+
+	data, err := json.Marshal(cachedTest{Key: key, Result: result})
+	if err != nil {
+		return errors.Wrap(err, "failed to Marshal")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "failed to MkdirAll %s", filepath.Dir(path))
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrapf(err, "failed to os.WriteFile %s", path)
+	}
+
+	return nil
+}
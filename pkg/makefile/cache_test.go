@@ -0,0 +1,128 @@
+package makefile
+
+import (
+	"context"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes the files to a new directory, returning the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+
+	for path, contents := range files {
+		full := filepath.Join(root, path)
+
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+
+		if err := os.WriteFile(full, []byte(contents), 0644); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+
+	return root
+}
+
+func cacheKey(t *testing.T, m *Makefile, c *Component) string {
+	t.Helper()
+
+	key, err := m.testCacheKey(context.Background(), c, []string{"BIN_DEST=/tmp/bin"})
+	if err != nil {
+		t.Fatalf("testCacheKey: %s", err)
+	}
+
+	return key
+}
+
+func TestTestCacheKeyEnv(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a/a.mk": "test:\n\t@true\n"})
+
+	c := &Component{Name: "a", Dir: filepath.Join(root, "a"), File: filepath.Join(root, "a", "a.mk"), PassEnv: []string{"A_TOKEN"}}
+	m := &Makefile{Components: []*Component{c}}
+
+	t.Setenv("UNRELATED", "one")
+	t.Setenv("A_TOKEN", "one")
+
+	key := cacheKey(t, m, c)
+
+	t.Setenv("UNRELATED", "two")
+
+	if cacheKey(t, m, c) != key {
+		t.Errorf("an unrelated variable changed the key")
+	}
+
+	t.Setenv("A_TOKEN", "two")
+
+	if cacheKey(t, m, c) == key {
+		t.Errorf("a passenv variable didn't change the key")
+	}
+}
+
+func TestTestCacheKeyFiles(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a/a.mk":                "test:\n\t@true\n",
+		"a/src.txt":             "one",
+		"a/node_modules/x.json": "one",
+	})
+
+	c := &Component{Name: "a", Dir: filepath.Join(root, "a"), File: filepath.Join(root, "a", "a.mk")}
+	m := &Makefile{Components: []*Component{c}}
+
+	key := cacheKey(t, m, c)
+
+	if err := os.WriteFile(filepath.Join(root, "a", "node_modules", "x.json"), []byte("two"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	if cacheKey(t, m, c) != key {
+		t.Errorf("a file in node_modules changed the key")
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "a", "src.txt"), []byte("two"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	if cacheKey(t, m, c) == key {
+		t.Errorf("a file in the component's directory didn't change the key")
+	}
+}
+
+func TestTestCacheKeyGoDeps(t *testing.T) {
+	if _, err := osexec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	root := writeFiles(t, map[string]string{
+		"go.mod":          "module example.com/repo\n\ngo 1.18\n",
+		"cmd/app/main.go": "package main\n\nimport \"example.com/repo/pkg/lib\"\n\nfunc main() { lib.Hello() }\n",
+		"pkg/lib/lib.go":  "package lib\n\nfunc Hello() {}\n",
+		"pkg/other/o.go":  "package other\n",
+	})
+
+	c := &Component{Name: "app", Dir: filepath.Join(root, "cmd", "app")}
+	m := &Makefile{Components: []*Component{c}}
+
+	key := cacheKey(t, m, c)
+
+	if err := os.WriteFile(filepath.Join(root, "pkg", "other", "o.go"), []byte("package other\n\n// changed\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	if cacheKey(t, m, c) != key {
+		t.Errorf("a package that isn't imported changed the key")
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "pkg", "lib", "lib.go"), []byte("package lib\n\nfunc Hello() { println() }\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	if cacheKey(t, m, c) == key {
+		t.Errorf("an imported package didn't change the key")
+	}
+}
@@ -4,4 +4,5 @@ package makefile
 type Check struct {
 	Cmd    string
 	Equals string
+	Pos    Pos
 }
@@ -1,43 +1,35 @@
 package makefile
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
 
-	"github.com/cohix/makeup/pkg/exec"
 	"github.com/pkg/errors"
 )
 
-// CleanAll sequentially runs each of the project components' clean targets
-func (m *Makefile) CleanAll() error {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return errors.Wrap(err, "failed to Getwd")
-	}
+// CleanAll sequentially runs each of the project components' clean targets, stopping at the first
+// failure unless KeepGoing is set
+func (m *Makefile) CleanAll(ctx context.Context) error {
+	return m.forEach(ctx, "clean", func(c *Component) error {
+		fmt.Println("cleaning:", c.Name)
 
-	binBase := filepath.Join(cwd, ".bin")
-
-	for _, incl := range m.Includes {
-		componentDir := filepath.Dir(incl.Path)
-		componentMakefile := filepath.Base(incl.Path)
-		componentName := strings.TrimSuffix(componentMakefile, ".mk")
-
-		fmt.Println("cleaning:", componentName)
-
-		binDest := filepath.Join(binBase, componentName)
+		env, err := m.targetEnv(c, "clean")
+		if err != nil {
+			return errors.Wrap(err, "failed to targetEnv")
+		}
 
-		env := []string{
-			fmt.Sprintf("BIN_DEST=%s", binDest),
+		if _, err := m.runTarget(ctx, c, "clean", nil, env); err != nil {
+			return errors.Wrapf(err, "failed to clean %s", c.Dir)
 		}
 
-		if _, err := exec.RunInDir(fmt.Sprintf("make -s -f %s clean", componentMakefile), componentDir, nil, env...); err != nil {
-			return errors.Wrapf(err, "failed to clean %s", componentDir)
+		// BIN_DIR belongs to makeup, so anything the clean target left behind (such as artifacts) is removed
+		if err := os.RemoveAll(m.binDir(c)); err != nil {
+			return errors.Wrapf(err, "failed to RemoveAll %s", m.binDir(c))
 		}
 
-		fmt.Println("clean complete:", componentName)
-	}
+		fmt.Println("clean complete:", c.Name)
 
-	return nil
+		return nil
+	})
 }
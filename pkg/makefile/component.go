@@ -0,0 +1,175 @@
+package makefile
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Component is a part of the project with build, run, test, env and clean targets
+type Component struct {
+	Name string
+	// Dir is the directory that the component's targets run in
+	Dir string
+	// File defines the component's targets (a .mk file, Taskfile, package.json etc), and is empty for zero-config Go components
+	File string
+	// Entry is the Procfile entry that the component runs
+	Entry    string
+	Driver   Driver
+	Extern   string
+	Optional bool
+	// MakeArgs are extra arguments passed to make when running the component's targets, from `#makeup: makeargs`
+	MakeArgs []string
+	// Timeouts limit how long each of the component's targets may run for, from `#makeup: timeout`
+	Timeouts map[string]time.Duration
+	// Hermetic stops the component from inheriting makeup's environment, other than PATH, HOME, TERM and PassEnv
+	Hermetic bool
+	PassEnv  []string
+	// Artifacts are the files that the component's build target creates in its BIN_DIR, from `#makeup: artifact`
+	Artifacts []string
+	// BinPath is "on" or "off" if `#makeup: binpath` chooses whether BIN_DIRs are added to the component's PATH
+	BinPath string
+	// Task marks a one-shot component (such as a migration) whose run target exits once it's done, from `#makeup: task`
+	Task bool
+	// After are the tasks whose run targets must succeed before the component's starts, from `#makeup: after`
+	After []string
+	// OnExit is what happens when the component's run target exits, from `#makeup: on-exit`
+	OnExit string
+	// TestJSON means that the component's test target outputs `go test -json`, from `#makeup: test-json`
+	TestJSON bool
+	Pos      Pos
+}
+
+// newFileComponent creates a component whose targets are defined by a file, using the given driver or the one matching the file's type
+func newFileComponent(path string, driver Driver, pos Pos) *Component {
+	if driver == nil {
+		driver = driverForFile(path)
+	}
+
+	c := &Component{
+		Name:   componentName(path),
+		Dir:    filepath.Dir(path),
+		File:   path,
+		Driver: driver,
+		Pos:    pos,
+	}
+
+	return c
+}
+
+// newGoComponent creates a zero-config Go component from a `#makeup: go` directive
+func newGoComponent(dir string, pos Pos) *Component {
+	c := &Component{
+		Name:   filepath.Base(filepath.Clean(dir)),
+		Dir:    dir,
+		Driver: drivers[goDriverName],
+		Pos:    pos,
+	}
+
+	return c
+}
+
+// source returns the file or directory that defines the component
+func (c *Component) source() string {
+	if c.File == "" {
+		return c.Dir
+	}
+
+	return c.File
+}
+
+// Root returns the project's root directory, which contains main.mk
+func (m *Makefile) Root() string {
+	return filepath.Dir(m.FullPath)
+}
+
+// binBase returns the directory that components' binaries are built into
+func (m *Makefile) binBase() string {
+	return filepath.Join(m.Root(), ".bin")
+}
+
+// binDir returns the directory that a component's binaries are built into
+func (m *Makefile) binDir(c *Component) string {
+	return filepath.Join(m.binBase(), c.Name)
+}
+
+// binDest returns the path that a component's main binary is built to
+func (m *Makefile) binDest(c *Component) string {
+	return filepath.Join(m.binDir(c), c.Name)
+}
+
+// ensureBinDir creates a component's BIN_DIR, removing a binary left in its place by older versions of
+// makeup which built straight to .bin/<component>
+func (m *Makefile) ensureBinDir(c *Component) error {
+	binDir := m.binDir(c)
+
+	if info, err := os.Stat(binDir); err == nil && !info.IsDir() {
+		if err := os.Remove(binDir); err != nil {
+			return errors.Wrapf(err, "failed to Remove %s", binDir)
+		}
+	}
+
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to MkdirAll %s", binDir)
+	}
+
+	return nil
+}
+
+// checkArtifacts returns an error if any of the component's artifacts are missing from its BIN_DIR
+func (m *Makefile) checkArtifacts(c *Component) error {
+	for _, a := range c.Artifacts {
+		path := filepath.Join(m.binDir(c), a)
+
+		if _, err := os.Stat(path); err != nil {
+			return errors.Wrapf(err, "build of %s did not create artifact %s", c.Name, a)
+		}
+	}
+
+	return nil
+}
+
+// dataDir returns the directory that a component can keep its data in
+func (m *Makefile) dataDir(c *Component) string {
+	return filepath.Join(m.Root(), ".makeup", "data", c.Name)
+}
+
+// logFile returns the path of the file that a component can write its logs to
+func (m *Makefile) logFile(c *Component) string {
+	return filepath.Join(m.Root(), ".makeup", "logs", c.Name+".log")
+}
+
+// absDir returns the absolute path of a component's directory
+func (m *Makefile) absDir(c *Component) string {
+	if filepath.IsAbs(c.Dir) {
+		return c.Dir
+	}
+
+	return filepath.Join(m.Root(), c.Dir)
+}
+
+// expandComponents replaces components whose driver defines several of them (such as a Procfile) with those components
+func (m *Makefile) expandComponents() error {
+	expanded := []*Component{}
+
+	for _, c := range m.Components {
+		exp, ok := c.Driver.(expander)
+		if !ok {
+			expanded = append(expanded, c)
+			continue
+		}
+
+		components, err := exp.Expand(c)
+		if err != nil {
+			return errors.Wrapf(err, "failed to Expand %s", c.File)
+		}
+
+		expanded = append(expanded, components...)
+	}
+
+	m.Components = expanded
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package makefile
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cohix/makeup/pkg/exec"
+	"github.com/pkg/errors"
+)
+
+// coverBlock is a block of statements in a Go coverage profile, such as `pkg/file.go:3.2,5.10 2 1`
+type coverBlock struct {
+	statements int
+	count      int
+}
+
+// coverProfile is a merged Go coverage profile
+type coverProfile struct {
+	mode   string
+	blocks map[string]*coverBlock
+}
+
+// coverageDir returns the directory that coverage is merged into
+func (m *Makefile) coverageDir() string {
+	return filepath.Join(m.Root(), ".makeup", "coverage")
+}
+
+// componentCoverageDir returns the directory that a component writes its coverage to
+func (m *Makefile) componentCoverageDir(c *Component) string {
+	return filepath.Join(m.coverageDir(), c.Name)
+}
+
+// coverEnv returns the variables that tell the component's targets where to write coverage: GOCOVERDIR
+// for binaries built with -cover, and MAKEUP_COVERPROFILE for `go test -coverprofile`
+func (m *Makefile) coverEnv(c *Component) ([]string, error) {
+	dir := m.componentCoverageDir(c)
+
+	if err := os.MkdirAll(filepath.Join(dir, "covdata"), 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to MkdirAll %s", dir)
+	}
+
+	env := []string{
+		fmt.Sprintf("GOCOVERDIR=%s", filepath.Join(dir, "covdata")),
+		fmt.Sprintf("MAKEUP_COVERPROFILE=%s", filepath.Join(dir, "cover.out")),
+	}
+
+	return env, nil
+}
+
+// resetCoverage removes the coverage that the component wrote when it was last tested
+func (m *Makefile) resetCoverage(c *Component) error {
+	dir := m.componentCoverageDir(c)
+
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "failed to RemoveAll %s", dir)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "covdata"), 0755); err != nil {
+		return errors.Wrapf(err, "failed to MkdirAll %s", dir)
+	}
+
+	return nil
+}
+
+// MergeCoverage merges the coverage written by each component into .makeup/coverage/coverage.out, along
+// with a summary of each package's coverage in coverage.txt and an HTML report in coverage.html, and prints
+// the total. Components whose tests were cached keep the coverage from when they were last tested.
+func (m *Makefile) MergeCoverage(ctx context.Context) error {
+	dir := m.coverageDir()
+
+	profile := &coverProfile{blocks: map[string]*coverBlock{}}
+	covdata := []string{}
+
+	for _, c := range m.Components {
+		componentDir := m.componentCoverageDir(c)
+
+		if err := profile.add(filepath.Join(componentDir, "cover.out")); err != nil {
+			return errors.Wrapf(err, "failed to read coverage of %s", c.Name)
+		}
+
+		if entries, err := os.ReadDir(filepath.Join(componentDir, "covdata")); err == nil && len(entries) > 0 {
+			covdata = append(covdata, filepath.Join(componentDir, "covdata"))
+		}
+	}
+
+	// coverage from binaries built with -cover is converted to a profile so that it can be merged
+	if len(covdata) > 0 {
+		converted := filepath.Join(dir, "covdata.out")
+
+		cmd := exec.New("go", "tool", "covdata", "textfmt", "-i="+strings.Join(covdata, ","), "-o="+converted)
+		if out, err := cmd.Run(ctx); err != nil {
+			return errors.Wrapf(err, "failed to convert GOCOVERDIR data: %s", strings.TrimSpace(out))
+		}
+
+		if err := profile.add(converted); err != nil {
+			return errors.Wrap(err, "failed to read converted GOCOVERDIR data")
+		}
+	}
+
+	if len(profile.blocks) == 0 {
+		fmt.Println("coverage: no coverage was written (Go components need '#makeup: go' or to use MAKEUP_COVERPROFILE)")
+		return nil
+	}
+
+	merged := filepath.Join(dir, "coverage.out")
+	if err := profile.write(merged); err != nil {
+		return errors.Wrap(err, "failed to write merged profile")
+	}
+
+	summary := filepath.Join(dir, "coverage.txt")
+	if err := profile.writeSummary(summary); err != nil {
+		return errors.Wrap(err, "failed to write coverage summary")
+	}
+
+	html := filepath.Join(dir, "coverage.html")
+
+	// the report needs the source of every package, which may not be found for those outside the root module
+	cmd := exec.New("go", "tool", "cover", "-html="+merged, "-o="+html)
+	cmd.Dir = m.Root()
+
+	if out, err := cmd.Run(ctx); err != nil {
+		fmt.Println("coverage html not written:", strings.TrimSpace(out))
+	} else {
+		fmt.Println("coverage html written:", html)
+	}
+
+	covered, total := profile.covered("")
+
+	fmt.Printf("coverage: %s of statements (%s)\n", percent(covered, total), summary)
+
+	return nil
+}
+
+// add merges the profile at path, if it exists. Blocks in more than one profile (such as packages tested
+// by several components) have their counts added, or are covered if any of them are in set mode.
+func (p *coverProfile) add(profilePath string) error {
+	file, err := os.Open(profilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return errors.Wrapf(err, "failed to Open %s", profilePath)
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "mode: ") {
+			mode := strings.TrimPrefix(line, "mode: ")
+
+			if p.mode == "" {
+				p.mode = mode
+			} else if p.mode != mode {
+				p.mode = "set"
+			}
+
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid line in %s: %s", profilePath, line)
+		}
+
+		statements, stmtErr := strconv.Atoi(fields[1])
+		count, countErr := strconv.Atoi(fields[2])
+
+		if stmtErr != nil || countErr != nil {
+			return fmt.Errorf("invalid line in %s: %s", profilePath, line)
+		}
+
+		block, ok := p.blocks[fields[0]]
+		if !ok {
+			p.blocks[fields[0]] = &coverBlock{statements: statements, count: count}
+			continue
+		}
+
+		block.count += count
+	}
+
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "failed to read %s", profilePath)
+	}
+
+	return nil
+}
+
+func (p *coverProfile) write(profilePath string) error {
+	mode := p.mode
+	if mode == "" {
+		mode = "set"
+	}
+
+	out := strings.Builder{}
+	fmt.Fprintf(&out, "mode: %s\n", mode)
+
+	for _, name := range p.names() {
+		block := p.blocks[name]
+
+		count := block.count
+		if mode == "set" && count > 1 {
+			count = 1
+		}
+
+		fmt.Fprintf(&out, "%s %d %d\n", name, block.statements, count)
+	}
+
+	if err := os.WriteFile(profilePath, []byte(out.String()), 0644); err != nil {
+		return errors.Wrapf(err, "failed to os.WriteFile %s", profilePath)
+	}
+
+	return nil
+}
+
+// writeSummary writes the coverage of each package and the total
+func (p *coverProfile) writeSummary(summaryPath string) error {
+	packages := []string{}
+	seen := map[string]bool{}
+
+	for _, name := range p.names() {
+		if pkg := blockPackage(name); !seen[pkg] {
+			seen[pkg] = true
+			packages = append(packages, pkg)
+		}
+	}
+
+	out := strings.Builder{}
+	table := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "PACKAGE\tCOVERAGE")
+
+	for _, pkg := range packages {
+		covered, total := p.covered(pkg)
+		fmt.Fprintf(table, "%s\t%s\n", pkg, percent(covered, total))
+	}
+
+	covered, total := p.covered("")
+	fmt.Fprintf(table, "total\t%s\n", percent(covered, total))
+
+	table.Flush()
+
+	if err := os.WriteFile(summaryPath, []byte(out.String()), 0644); err != nil {
+		return errors.Wrapf(err, "failed to os.WriteFile %s", summaryPath)
+	}
+
+	return nil
+}
+
+// covered returns how many of the package's statements were covered and how many there are, for every
+// package if pkg is empty
+func (p *coverProfile) covered(pkg string) (int, int) {
+	covered, total := 0, 0
+
+	for name, block := range p.blocks {
+		if pkg != "" && blockPackage(name) != pkg {
+			continue
+		}
+
+		total += block.statements
+		if block.count > 0 {
+			covered += block.statements
+		}
+	}
+
+	return covered, total
+}
+
+// names returns the profile's blocks in order
+func (p *coverProfile) names() []string {
+	names := []string{}
+	for name := range p.blocks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// blockPackage returns the package of a block such as `example.com/pkg/file.go:3.2,5.10`
+func blockPackage(name string) string {
+	file, _, _ := strings.Cut(name, ":")
+
+	return path.Dir(file)
+}
+
+func percent(covered, total int) string {
+	if total == 0 {
+		return "0.0%"
+	}
+
+	return fmt.Sprintf("%.1f%%", float64(covered)*100/float64(total))
+}
@@ -0,0 +1,124 @@
+package makefile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCoverProfileMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []string
+		want     string
+		covered  int
+		total    int
+	}{
+		{
+			name: "count mode adds counts",
+			profiles: []string{
+				"mode: count\nex.com/a/a.go:1.1,2.2 2 1\nex.com/a/a.go:3.1,4.2 3 0\n",
+				"mode: count\nex.com/a/a.go:1.1,2.2 2 2\nex.com/b/b.go:1.1,2.2 5 0\n",
+			},
+			want:    "mode: count\nex.com/a/a.go:1.1,2.2 2 3\nex.com/a/a.go:3.1,4.2 3 0\nex.com/b/b.go:1.1,2.2 5 0\n",
+			covered: 2,
+			total:   10,
+		},
+		{
+			name: "set mode is covered by either profile",
+			profiles: []string{
+				"mode: set\nex.com/a/a.go:1.1,2.2 2 1\nex.com/a/a.go:3.1,4.2 3 0\n",
+				"mode: set\nex.com/a/a.go:1.1,2.2 2 1\nex.com/a/a.go:3.1,4.2 3 1\n",
+			},
+			want:    "mode: set\nex.com/a/a.go:1.1,2.2 2 1\nex.com/a/a.go:3.1,4.2 3 1\n",
+			covered: 5,
+			total:   5,
+		},
+		{
+			name: "mixed modes fall back to set",
+			profiles: []string{
+				"mode: atomic\nex.com/a/a.go:1.1,2.2 2 4\n",
+				"mode: set\nex.com/a/a.go:3.1,4.2 3 0\n",
+			},
+			want:    "mode: set\nex.com/a/a.go:1.1,2.2 2 1\nex.com/a/a.go:3.1,4.2 3 0\n",
+			covered: 2,
+			total:   5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			profile := &coverProfile{blocks: map[string]*coverBlock{}}
+
+			for i, contents := range tt.profiles {
+				path := filepath.Join(dir, string(rune('a'+i))+".out")
+
+				if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+					t.Fatalf("WriteFile: %s", err)
+				}
+
+				if err := profile.add(path); err != nil {
+					t.Fatalf("add: %s", err)
+				}
+			}
+
+			// a missing profile is skipped, as for components that wrote none
+			if err := profile.add(filepath.Join(dir, "missing.out")); err != nil {
+				t.Fatalf("add: %s", err)
+			}
+
+			out := filepath.Join(dir, "coverage.out")
+			if err := profile.write(out); err != nil {
+				t.Fatalf("write: %s", err)
+			}
+
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatalf("ReadFile: %s", err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+
+			if covered, total := profile.covered(""); covered != tt.covered || total != tt.total {
+				t.Errorf("got %d/%d covered, want %d/%d", covered, total, tt.covered, tt.total)
+			}
+		})
+	}
+}
+
+func TestCoverProfileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cover.out")
+
+	if err := os.WriteFile(path, []byte("mode: set\nex.com/a/a.go:1.1,2.2 x 1\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	profile := &coverProfile{blocks: map[string]*coverBlock{}}
+	if err := profile.add(path); err == nil {
+		t.Errorf("got no error for an invalid line")
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		covered, total int
+		want           string
+	}{
+		{0, 0, "0.0%"},
+		{1, 3, "33.3%"},
+		{5, 5, "100.0%"},
+	}
+
+	for _, tt := range tests {
+		if got := percent(tt.covered, tt.total); got != tt.want {
+			t.Errorf("percent(%d, %d) got %q, want %q", tt.covered, tt.total, got, tt.want)
+		}
+	}
+
+	if got := blockPackage("ex.com/a/b/file.go:3.2,5.10"); got != "ex.com/a/b" {
+		t.Errorf("blockPackage got %q, want %q", got, "ex.com/a/b")
+	}
+}
@@ -0,0 +1,494 @@
+package makefile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cohix/makeup/pkg/exec"
+	"github.com/pkg/errors"
+)
+
+const (
+	makeDriverName     = "make"
+	goDriverName       = "go"
+	taskDriverName     = "task"
+	justDriverName     = "just"
+	npmDriverName      = "npm"
+	procfileDriverName = "procfile"
+)
+
+// Driver runs the lifecycle targets of a component using a particular task runner
+type Driver interface {
+	// Name returns the name that selects the driver in a `#makeup: driver` directive
+	Name() string
+	// Run runs one of the component's lifecycle targets, writing its output to inv.Out and returning it
+	Run(ctx context.Context, inv *Invocation) (string, error)
+	// Targets returns the lifecycle targets that the component defines
+	Targets(ctx context.Context, inv *Invocation) ([]string, error)
+}
+
+// Invocation is a run of one of a component's targets
+type Invocation struct {
+	Component *Component
+	Target    string
+	Out       io.Writer
+	Env       []string
+	// Hermetic means that Env is the complete environment, rather than being added to makeup's
+	Hermetic bool
+	Make     MakeConfig
+
+	// lastCommand is the most recent command run for the invocation, which is the one that failed if it did
+	lastCommand string
+}
+
+// command creates a Command that runs the program in dir with the invocation's env and output
+func (inv *Invocation) command(dir, program string, args ...string) *exec.Command {
+	return inv.setup(exec.New(program, args...), dir)
+}
+
+// shell creates a Command that runs a shell command written by the user in dir with the invocation's env and output
+func (inv *Invocation) shell(dir, cmd string) *exec.Command {
+	return inv.setup(exec.Shell(cmd), dir)
+}
+
+func (inv *Invocation) setup(cmd *exec.Command, dir string) *exec.Command {
+	cmd.Dir = dir
+	cmd.Env = inv.Env
+	cmd.Hermetic = inv.Hermetic
+	inv.lastCommand = cmd.String()
+	cmd.Stdout, cmd.Stderr = inv.Out, inv.Out
+
+	if inv.Out == nil {
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	}
+
+	return cmd
+}
+
+// expander is implemented by drivers whose files define several components
+type expander interface {
+	Expand(c *Component) ([]*Component, error)
+}
+
+var drivers = map[string]Driver{
+	makeDriverName:     makeDriver{},
+	goDriverName:       goDriver{},
+	taskDriverName:     taskDriver{},
+	justDriverName:     justDriver{},
+	npmDriverName:      npmDriver{},
+	procfileDriverName: procfileDriver{},
+}
+
+// driverForFile returns the driver for a component file based on its name, defaulting to make
+func driverForFile(path string) Driver {
+	base := filepath.Base(path)
+	lower := strings.ToLower(base)
+
+	switch {
+	case strings.HasPrefix(lower, "taskfile.") && (strings.HasSuffix(lower, ".yml") || strings.HasSuffix(lower, ".yaml")):
+		return drivers[taskDriverName]
+	case lower == "justfile" || lower == ".justfile" || strings.HasSuffix(lower, ".just"):
+		return drivers[justDriverName]
+	case base == "package.json":
+		return drivers[npmDriverName]
+	case base == "Procfile" || strings.HasPrefix(base, "Procfile."):
+		return drivers[procfileDriverName]
+	}
+
+	return drivers[makeDriverName]
+}
+
+// makeDriver runs targets from a Makefile
+type makeDriver struct{}
+
+func (makeDriver) Name() string {
+	return makeDriverName
+}
+
+func (makeDriver) Run(ctx context.Context, inv *Invocation) (string, error) {
+	c := inv.Component
+
+	return runMake(ctx, filepath.Base(c.File), c.Dir, c.MakeArgs, inv)
+}
+
+// Targets introspects the make database of the component's Makefile
+func (makeDriver) Targets(ctx context.Context, inv *Invocation) ([]string, error) {
+	c := inv.Component
+
+	componentMakefile := filepath.Base(c.File)
+
+	// -q causes make to exit non-zero since the ':' goal never exists, so the error is ignored and the output checked instead
+	cmd := exec.New(inv.Make.Binary(), inv.Make.args(componentMakefile, ":", "-pRrq", c.MakeArgs)...)
+	cmd.Dir = c.Dir
+
+	out, _ := cmd.Run(ctx)
+
+	if !strings.Contains(out, "# Make data base") {
+		return nil, fmt.Errorf("make did not output a database: %s", strings.TrimSpace(out))
+	}
+
+	targets := []string{}
+
+	notTarget := false
+
+	for _, l := range strings.Split(out, "\n") {
+		// syntax errors are reported as file:line: *** message
+		if strings.HasPrefix(l, componentMakefile+":") && strings.Contains(l, "*** ") {
+			return nil, errors.New(strings.TrimSpace(l))
+		}
+
+		if l == "# Not a target:" {
+			notTarget = true
+			continue
+		}
+
+		if l == "" || strings.HasPrefix(l, "#") || strings.HasPrefix(l, "\t") {
+			continue
+		}
+
+		if notTarget {
+			notTarget = false
+			continue
+		}
+
+		colon := strings.Index(l, ":")
+		if colon < 1 || strings.HasPrefix(l, ".") || strings.HasPrefix(l[colon:], ":=") || strings.Contains(l[:colon], "=") {
+			continue
+		}
+
+		targets = append(targets, l[:colon])
+	}
+
+	return targets, nil
+}
+
+// goDriver natively builds, runs, tests and cleans a zero-config Go component
+type goDriver struct{}
+
+func (goDriver) Name() string {
+	return goDriverName
+}
+
+func (goDriver) Run(ctx context.Context, inv *Invocation) (string, error) {
+	c := inv.Component
+
+	binDest := envValue(inv.Env, "BIN_DEST")
+
+	// coverage is written when makeup test is run with --cover
+	coverProfile := envValue(inv.Env, "MAKEUP_COVERPROFILE")
+
+	switch inv.Target {
+	case "build":
+		if coverProfile != "" {
+			return inv.command(c.Dir, "go", "build", "-cover", "-o", binDest).Run(ctx)
+		}
+
+		return inv.command(c.Dir, "go", "build", "-o", binDest).Run(ctx)
+	case "run":
+		return inv.command(c.Dir, binDest).Run(ctx)
+	case "test":
+		args := []string{"test"}
+		if c.TestJSON {
+			args = append(args, "-json")
+		}
+
+		if coverProfile != "" {
+			args = append(args, "-coverprofile="+coverProfile)
+		}
+
+		return inv.command(c.Dir, "go", append(args, "./...")...).Run(ctx)
+	case "env":
+		return readEnvFile(c.Dir)
+	case "clean":
+		if err := os.Remove(binDest); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", errors.Wrapf(err, "failed to Remove %s", binDest)
+		}
+
+		return "", nil
+	}
+
+	return "", fmt.Errorf("unknown target %s", inv.Target)
+}
+
+func (goDriver) Targets(context.Context, *Invocation) ([]string, error) {
+	return lifecycleTargets, nil
+}
+
+// taskDriver runs targets from a Taskfile (https://taskfile.dev)
+type taskDriver struct{}
+
+func (taskDriver) Name() string {
+	return taskDriverName
+}
+
+func (taskDriver) Run(ctx context.Context, inv *Invocation) (string, error) {
+	c, target := inv.Component, inv.Target
+
+	return inv.command(c.Dir, "task", "--silent", "--taskfile", filepath.Base(c.File), target).Run(ctx)
+}
+
+func (taskDriver) Targets(ctx context.Context, inv *Invocation) ([]string, error) {
+	c := inv.Component
+
+	cmd := exec.New("task", "--taskfile", filepath.Base(c.File), "--list-all", "--json")
+	cmd.Dir = c.Dir
+
+	out, err := cmd.Run(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list tasks: %s", strings.TrimSpace(out))
+	}
+
+	list := struct {
+		Tasks []struct {
+			Name string `json:"name"`
+		} `json:"tasks"`
+	}{}
+
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, errors.Wrap(err, "failed to Unmarshal task list")
+	}
+
+	targets := []string{}
+	for _, t := range list.Tasks {
+		targets = append(targets, t.Name)
+	}
+
+	return targets, nil
+}
+
+// justDriver runs recipes from a justfile (https://just.systems)
+type justDriver struct{}
+
+func (justDriver) Name() string {
+	return justDriverName
+}
+
+func (justDriver) Run(ctx context.Context, inv *Invocation) (string, error) {
+	c, target := inv.Component, inv.Target
+
+	return inv.command(c.Dir, "just", "--justfile", filepath.Base(c.File), target).Run(ctx)
+}
+
+func (justDriver) Targets(ctx context.Context, inv *Invocation) ([]string, error) {
+	c := inv.Component
+
+	cmd := exec.New("just", "--justfile", filepath.Base(c.File), "--summary")
+	cmd.Dir = c.Dir
+
+	out, err := cmd.Run(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list recipes: %s", strings.TrimSpace(out))
+	}
+
+	return strings.Fields(out), nil
+}
+
+// npmDriver runs scripts from a package.json. The run target uses the `start` script if there is no `run`
+// script, and the other targets do nothing if there is no script for them.
+type npmDriver struct{}
+
+func (npmDriver) Name() string {
+	return npmDriverName
+}
+
+func (d npmDriver) Run(ctx context.Context, inv *Invocation) (string, error) {
+	c, target := inv.Component, inv.Target
+
+	scripts, err := d.scripts(c)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read scripts")
+	}
+
+	script := target
+	if _, ok := scripts[script]; !ok && target == "run" {
+		script = "start"
+	}
+
+	if _, ok := scripts[script]; !ok {
+		if target == "run" {
+			return "", fmt.Errorf("%s has no run or start script", c.File)
+		}
+
+		return "", nil
+	}
+
+	return inv.command(c.Dir, "npm", "run", "--silent", script).Run(ctx)
+}
+
+func (d npmDriver) Targets(ctx context.Context, inv *Invocation) ([]string, error) {
+	c := inv.Component
+
+	scripts, err := d.scripts(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read scripts")
+	}
+
+	targets := []string{"build", "test", "env", "clean"}
+
+	_, hasRun := scripts["run"]
+	_, hasStart := scripts["start"]
+
+	if hasRun || hasStart {
+		targets = append(targets, "run")
+	}
+
+	for _, target := range optionalTargets {
+		if _, ok := scripts[target]; ok {
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
+
+func (npmDriver) scripts(c *Component) (map[string]string, error) {
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to ReadFile %s", c.File)
+	}
+
+	pkg := struct {
+		Scripts map[string]string `json:"scripts"`
+	}{}
+
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, errors.Wrapf(err, "failed to Unmarshal %s", c.File)
+	}
+
+	return pkg.Scripts, nil
+}
+
+// procfileDriver runs an entry from a Procfile. Each entry becomes a component with the entry's name, whose
+// env comes from the optional .env file next to the Procfile, and whose other targets do nothing.
+type procfileDriver struct{}
+
+func (procfileDriver) Name() string {
+	return procfileDriverName
+}
+
+func (procfileDriver) Run(ctx context.Context, inv *Invocation) (string, error) {
+	c, target := inv.Component, inv.Target
+
+	switch target {
+	case "run":
+		entries, err := readProcfile(c.File)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to readProcfile")
+		}
+
+		for _, e := range entries {
+			if e.Name == c.Entry {
+				return inv.shell(c.Dir, e.Cmd).Run(ctx)
+			}
+		}
+
+		return "", fmt.Errorf("%s has no entry %s", c.File, c.Entry)
+	case "env":
+		return readEnvFile(c.Dir)
+	}
+
+	return "", nil
+}
+
+func (procfileDriver) Targets(context.Context, *Invocation) ([]string, error) {
+	return lifecycleTargets, nil
+}
+
+// Expand creates a component for each entry of the Procfile
+func (procfileDriver) Expand(c *Component) ([]*Component, error) {
+	entries, err := readProcfile(c.File)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to readProcfile")
+	}
+
+	components := []*Component{}
+
+	for _, e := range entries {
+		entry := *c
+		entry.Name = e.Name
+		entry.Entry = e.Name
+
+		components = append(components, &entry)
+	}
+
+	return components, nil
+}
+
+// procfileEntry is a `name: command` line of a Procfile
+type procfileEntry struct {
+	Name string
+	Cmd  string
+}
+
+// readProcfile reads the entries of a Procfile in the order they appear
+func readProcfile(path string) ([]procfileEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to ReadFile %s", path)
+	}
+
+	entries := []procfileEntry{}
+
+	for _, l := range strings.Split(string(data), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		colon := strings.Index(l, ":")
+		if colon < 1 {
+			continue
+		}
+
+		entry := procfileEntry{
+			Name: strings.TrimSpace(l[:colon]),
+			Cmd:  strings.TrimSpace(l[colon+1:]),
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// readEnvFile reads the optional .env file in dir, returning its KEY=VALUE lines
+func readEnvFile(dir string) (string, error) {
+	envFile, err := os.ReadFile(filepath.Join(dir, ".env"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+
+		return "", errors.Wrap(err, "failed to ReadFile .env")
+	}
+
+	envLines := []string{}
+
+	for _, l := range strings.Split(string(envFile), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(l), "#") {
+			continue
+		}
+
+		envLines = append(envLines, strings.TrimSpace(l))
+	}
+
+	return strings.Join(envLines, "\n"), nil
+}
+
+// envValue returns the value of key in a list of KEY=VALUE env vars, where later values take precedence
+func envValue(env []string, key string) string {
+	value := ""
+
+	for _, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			value = strings.TrimPrefix(e, key+"=")
+		}
+	}
+
+	return value
+}
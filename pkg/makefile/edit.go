@@ -0,0 +1,456 @@
+package makefile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Positions of nodes refer to the source a File was parsed from, and are not updated by edits.
+// Nodes that are not modified are written back out exactly as they were read, so comments and
+// formatting are preserved.
+
+// NewFile creates an empty File that will be written to the given path
+func NewFile(path string) *File {
+	f := &File{
+		Path:     path,
+		Nodes:    []Node{},
+		Warnings: []Diagnostic{},
+	}
+
+	return f
+}
+
+// NewInclude creates an `include` statement for the given paths
+func NewInclude(paths ...string) *Include {
+	incl := &Include{
+		source:  source{pos: Pos{Line: 1, Col: 1}, raw: []string{"include " + strings.Join(paths, " ")}},
+		Keyword: "include",
+		Paths:   []IncludePath{},
+	}
+
+	col := len("include ") + 1
+
+	for _, p := range paths {
+		incl.Paths = append(incl.Paths, IncludePath{Path: p, Pos: Pos{Line: 1, Col: col}})
+		col += len(p) + 1
+	}
+
+	return incl
+}
+
+// NewDirective creates a `#makeup: name value` directive, or `# name value` for the legacy directives
+func NewDirective(name, value string) *Directive {
+	return newDirective(name, value, !legacyDirectives[name])
+}
+
+func newDirective(name, value string, prefixed bool) *Directive {
+	text := "# " + name
+	if prefixed {
+		text = directivePrefix + " " + name
+	}
+
+	valueCol := len(text) + 2
+
+	if value != "" {
+		text = text + " " + value
+	}
+
+	d := &Directive{
+		source:   source{pos: Pos{Line: 1, Col: 1}, raw: []string{text}},
+		Name:     name,
+		Value:    value,
+		ValuePos: Pos{Line: 1, Col: valueCol},
+	}
+
+	return d
+}
+
+// NewBlank creates an empty line
+func NewBlank() *Blank {
+	return &Blank{source: source{pos: Pos{Line: 1, Col: 1}, raw: []string{""}}}
+}
+
+// Bytes renders the File back into Makefile source
+func (f *File) Bytes() []byte {
+	newline := "\n"
+	if f.crlf {
+		newline = "\r\n"
+	}
+
+	buf := bytes.Buffer{}
+
+	for _, n := range f.Nodes {
+		for _, l := range n.Raw() {
+			buf.WriteString(l)
+			buf.WriteString(newline)
+		}
+	}
+
+	out := buf.Bytes()
+
+	if f.noFinalNewline {
+		out = bytes.TrimSuffix(out, []byte(newline))
+	}
+
+	return out
+}
+
+// Write writes the File back to its path
+func (f *File) Write() error {
+	if err := os.WriteFile(f.Path, f.Bytes(), 0644); err != nil {
+		return errors.Wrapf(err, "failed to os.WriteFile %s", f.Path)
+	}
+
+	return nil
+}
+
+// Index returns the index of the node within the File, or -1 if it is not present
+func (f *File) Index(n Node) int {
+	for i, node := range f.Nodes {
+		if node == n {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Insert inserts nodes into the File at the given index
+func (f *File) Insert(i int, nodes ...Node) {
+	if i < 0 || i > len(f.Nodes) {
+		i = len(f.Nodes)
+	}
+
+	updated := append([]Node{}, f.Nodes[:i]...)
+	updated = append(updated, nodes...)
+	updated = append(updated, f.Nodes[i:]...)
+
+	f.Nodes = updated
+}
+
+// Remove removes the node from the File, returning false if it was not present
+func (f *File) Remove(n Node) bool {
+	i := f.Index(n)
+	if i < 0 {
+		return false
+	}
+
+	f.Nodes = append(f.Nodes[:i], f.Nodes[i+1:]...)
+
+	return true
+}
+
+// AppendInclude adds an include statement for the path after the last include in the File
+func (f *File) AppendInclude(path string) *Include {
+	incl := NewInclude(path)
+
+	last := -1
+	for i, n := range f.Nodes {
+		if _, ok := n.(*Include); ok {
+			last = i
+		}
+	}
+
+	if last < 0 {
+		if len(f.Nodes) > 0 {
+			f.Insert(len(f.Nodes), NewBlank())
+		}
+
+		f.Insert(len(f.Nodes), incl)
+	} else {
+		f.Insert(last+1, incl)
+	}
+
+	return incl
+}
+
+// AppendCheck adds a `# check` and `# equal` pair after the last check in the File, or at the top if there are none
+func (f *File) AppendCheck(cmd, equals string) {
+	last := -1
+	for i, n := range f.Nodes {
+		if d, ok := n.(*Directive); ok && d.Name == equalDirective {
+			last = i
+		}
+	}
+
+	f.Insert(last+1, NewDirective(checkDirective, cmd), NewDirective(equalDirective, equals))
+}
+
+// FindInclude finds the include statement listing the given path, returning it and the index of the path within it
+func (f *File) FindInclude(path string) (*Include, int) {
+	for _, n := range f.Nodes {
+		incl, ok := n.(*Include)
+		if !ok {
+			continue
+		}
+
+		for i, p := range incl.Paths {
+			if filepath.Clean(p.Path) == filepath.Clean(path) {
+				return incl, i
+			}
+		}
+	}
+
+	return nil, -1
+}
+
+// FindComponent finds the include statement for the named component, returning it and the index of the path within it
+func (f *File) FindComponent(name string) (*Include, int) {
+	for _, n := range f.Nodes {
+		incl, ok := n.(*Include)
+		if !ok {
+			continue
+		}
+
+		for i, p := range incl.Paths {
+			if f.declaredName(incl, componentName(p.Path)) == name {
+				return incl, i
+			}
+		}
+	}
+
+	return nil, -1
+}
+
+// FindComponentDirective finds the `#makeup: go` or `#makeup: include` directive declaring the named component
+func (f *File) FindComponentDirective(name string) *Directive {
+	for _, n := range f.Nodes {
+		d, ok := n.(*Directive)
+		if !ok {
+			continue
+		}
+
+		if d.Name == goDirective && f.declaredName(d, newGoComponent(d.Value, d.Pos()).Name) == name {
+			return d
+		}
+
+		if d.Name == includeDirective && f.declaredName(d, componentName(d.Value)) == name {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// SetIncludePath replaces the i'th path of the include, leaving the rest of the line untouched
+func (f *File) SetIncludePath(incl *Include, i int, path string) {
+	f.splice(incl, i, path)
+	incl.Paths[i].Path = path
+}
+
+// RemoveIncludePath removes the i'th path of the include, removing the whole statement (and its
+// modifiers) if it was the only path
+func (f *File) RemoveIncludePath(incl *Include, i int) {
+	if len(incl.Paths) == 1 {
+		for _, m := range f.Modifiers(incl) {
+			f.Remove(m)
+		}
+
+		f.Remove(incl)
+
+		return
+	}
+
+	f.splice(incl, i, "")
+	incl.Paths = append(incl.Paths[:i], incl.Paths[i+1:]...)
+}
+
+// Modifiers returns the directives that apply to the component declared by the given node, such as `# extern`
+func (f *File) Modifiers(n Node) []*Directive {
+	mods := []*Directive{}
+
+	sawBlank := false
+
+	for i := f.Index(n) - 1; i >= 0; i-- {
+		if _, ok := f.Nodes[i].(*Blank); ok {
+			sawBlank = true
+			continue
+		}
+
+		d, ok := f.Nodes[i].(*Directive)
+		if !ok || !modifierDirectives[d.Name] {
+			break
+		}
+
+		// directives such as `#makeup: timeout` apply to the whole project when separated from the component
+		if sawBlank && globalDirectives[d.Name] {
+			break
+		}
+
+		mods = append([]*Directive{d}, mods...)
+	}
+
+	return mods
+}
+
+// NameDirective returns the `#makeup: name` directive that renames the component declared by the given node, or nil
+func (f *File) NameDirective(n Node) *Directive {
+	var name *Directive
+
+	for _, m := range f.Modifiers(n) {
+		if m.Name == nameDirective {
+			name = m
+		}
+	}
+
+	return name
+}
+
+// declaredName returns the name of the component declared by the node, which is given by its
+// `#makeup: name` directive if it has one and is otherwise the default
+func (f *File) declaredName(n Node, defaultName string) string {
+	if d := f.NameDirective(n); d != nil {
+		return d.Value
+	}
+
+	return defaultName
+}
+
+// SetDirectiveValue replaces the value of the directive, keeping the form it was written in
+func (f *File) SetDirectiveValue(d *Directive, value string) {
+	prefixed := strings.HasPrefix(strings.TrimSpace(d.raw[0]), directivePrefix)
+	replacement := newDirective(d.Name, value, prefixed)
+
+	d.Value = value
+	d.raw = replacement.raw
+}
+
+// RenameAfter replaces the old name of a task in the `#makeup: after` directives that list it
+func (f *File) RenameAfter(oldName, newName string) {
+	for _, n := range f.Nodes {
+		d, ok := n.(*Directive)
+		if !ok || d.Name != afterDirective {
+			continue
+		}
+
+		names := strings.Fields(d.Value)
+		for i := range names {
+			if names[i] == oldName {
+				names[i] = newName
+				f.SetDirectiveValue(d, strings.Join(names, " "))
+			}
+		}
+	}
+}
+
+// Overrides returns the rules in the File that override targets of the named component
+func (f *File) Overrides(component string) []*Rule {
+	rules := []*Rule{}
+
+	for i, n := range f.Nodes {
+		d, ok := n.(*Directive)
+		if !ok || d.Name != overrideDirective {
+			continue
+		}
+
+		rule, ok := nodeAt(f.Nodes, nextNonBlank(f.Nodes, i)).(*Rule)
+		if !ok || len(rule.Targets) != 1 {
+			continue
+		}
+
+		if strings.HasPrefix(rule.Targets[0], component+"/") {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+// RemoveOverride removes an override rule along with its `# override` directive
+func (f *File) RemoveOverride(rule *Rule) {
+	i := f.Index(rule)
+
+	for j := i - 1; j >= 0; j-- {
+		if _, ok := f.Nodes[j].(*Blank); ok {
+			continue
+		}
+
+		if d, ok := f.Nodes[j].(*Directive); ok && d.Name == overrideDirective {
+			f.Remove(d)
+		}
+
+		break
+	}
+
+	f.Remove(rule)
+}
+
+// RenameTarget renames the first target of the rule, leaving the rest of the rule untouched
+func (f *File) RenameTarget(rule *Rule, target string) {
+	old := rule.Targets[0]
+
+	if i := strings.Index(rule.raw[0], old); i >= 0 {
+		rule.raw[0] = rule.raw[0][:i] + target + rule.raw[0][i+len(old):]
+	}
+
+	rule.Targets[0] = target
+}
+
+// splice replaces the text of the i'th path of an include within its raw lines
+func (f *File) splice(incl *Include, i int, replacement string) {
+	p := incl.Paths[i]
+
+	rawIdx := p.Pos.Line - incl.pos.Line
+	start := p.Pos.Col - 1
+	end := start + len(p.Path)
+
+	line := incl.raw[rawIdx]
+
+	if replacement == "" {
+		// remove the whitespace preceding the path along with it
+		for start > 0 && (line[start-1] == ' ' || line[start-1] == '\t') {
+			start--
+		}
+	}
+
+	incl.raw[rawIdx] = line[:start] + replacement + line[end:]
+
+	delta := len(replacement) - (end - start)
+
+	for j := range incl.Paths {
+		if j != i && incl.Paths[j].Pos.Line == p.Pos.Line && incl.Paths[j].Pos.Col > p.Pos.Col {
+			incl.Paths[j].Pos.Col += delta
+		}
+	}
+
+	incl.Paths[i].Pos.Col = start + 1
+
+	if replacement == "" && rawIdx > 0 && strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(incl.raw[rawIdx]), "\\")) == "" {
+		removeRawLine(incl, rawIdx)
+	}
+}
+
+// removeRawLine removes a continuation line of the include that no longer lists any paths. If it was the
+// last line, the backslash continuing the line before it is removed too.
+func removeRawLine(incl *Include, rawIdx int) {
+	last := !strings.HasSuffix(strings.TrimSpace(incl.raw[rawIdx]), "\\")
+
+	incl.raw = append(incl.raw[:rawIdx], incl.raw[rawIdx+1:]...)
+
+	if last {
+		prev := strings.TrimRight(incl.raw[rawIdx-1], " \t")
+		incl.raw[rawIdx-1] = strings.TrimRight(strings.TrimSuffix(prev, "\\"), " \t")
+	}
+
+	line := incl.pos.Line + rawIdx
+
+	for j := range incl.Paths {
+		if incl.Paths[j].Pos.Line > line {
+			incl.Paths[j].Pos.Line--
+		}
+	}
+}
+
+// componentName returns the name of the component defined by the file at the given path, which is the
+// name of a .mk file or otherwise the name of the directory containing it
+func componentName(path string) string {
+	if filepath.Ext(path) == ".mk" {
+		return strings.TrimSuffix(filepath.Base(path), ".mk")
+	}
+
+	return filepath.Base(filepath.Dir(filepath.Clean(path)))
+}
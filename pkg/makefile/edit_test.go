@@ -0,0 +1,113 @@
+package makefile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRemoveIncludePath(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		path string
+		want string
+	}{
+		{
+			name: "only path",
+			src:  "#makeup: timeout build 5m\ninclude ./a/a.mk\ninclude ./b/b.mk\n",
+			path: "./a/a.mk",
+			want: "include ./b/b.mk\n",
+		},
+		{
+			name: "one of several on a line",
+			src:  "include ./a/a.mk ./b/b.mk ./c/c.mk\n",
+			path: "./b/b.mk",
+			want: "include ./a/a.mk ./c/c.mk\n",
+		},
+		{
+			name: "first line",
+			src:  "include ./a/a.mk \\\n\t./b/b.mk\n",
+			path: "./a/a.mk",
+			want: "include \\\n\t./b/b.mk\n",
+		},
+		{
+			name: "only path on the last line",
+			src:  "include ./a/a.mk \\\n\t./b/b.mk\n",
+			path: "./b/b.mk",
+			want: "include ./a/a.mk\n",
+		},
+		{
+			name: "only path on a middle line",
+			src:  "include ./a/a.mk \\\n\t./b/b.mk \\\n\t./c/c.mk\n",
+			path: "./b/b.mk",
+			want: "include ./a/a.mk \\\n\t./c/c.mk\n",
+		},
+		{
+			name: "one of several on a continuation line",
+			src:  "include ./a/a.mk \\\n\t./b/b.mk ./c/c.mk\n",
+			path: "./c/c.mk",
+			want: "include ./a/a.mk \\\n\t./b/b.mk\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseAST("main.mk", strings.NewReader(tt.src))
+			if err != nil {
+				t.Fatalf("parseAST: %s", err)
+			}
+
+			incl, i := f.FindInclude(tt.path)
+			if incl == nil {
+				t.Fatalf("FindInclude: %s not found", tt.path)
+			}
+
+			f.RemoveIncludePath(incl, i)
+
+			if got := string(f.Bytes()); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveIncludePaths(t *testing.T) {
+	f, err := parseAST("main.mk", strings.NewReader("include ./a/a.mk \\\n\t./b/b.mk \\\n\t./c/c.mk \\\n\t./d/d.mk\n"))
+	if err != nil {
+		t.Fatalf("parseAST: %s", err)
+	}
+
+	// each removal has to find the paths after it where the ones before it left them
+	for _, path := range []string{"./b/b.mk", "./d/d.mk", "./c/c.mk"} {
+		incl, i := f.FindInclude(path)
+		if incl == nil {
+			t.Fatalf("FindInclude: %s not found", path)
+		}
+
+		f.RemoveIncludePath(incl, i)
+	}
+
+	if got, want := string(f.Bytes()), "include ./a/a.mk\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSetIncludePath(t *testing.T) {
+	f, err := parseAST("main.mk", strings.NewReader("include ./a/a.mk ./b/b.mk \\\n\t./c/c.mk\n"))
+	if err != nil {
+		t.Fatalf("parseAST: %s", err)
+	}
+
+	for _, rename := range [][2]string{{"./a/a.mk", "./api/api.mk"}, {"./b/b.mk", "./b2/b.mk"}, {"./c/c.mk", "./c/c2.mk"}} {
+		incl, i := f.FindInclude(rename[0])
+		if incl == nil {
+			t.Fatalf("FindInclude: %s not found", rename[0])
+		}
+
+		f.SetIncludePath(incl, i, rename[1])
+	}
+
+	if got, want := string(f.Bytes()), "include ./api/api.mk ./b2/b.mk \\\n\t./c/c2.mk\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,222 @@
+package makefile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// hermeticAllowlist are the variables that hermetic components always inherit
+var hermeticAllowlist = []string{"PATH", "HOME", "TERM"}
+
+// targetEnv returns the variables that makeup provides to each of the component's targets while
+// running the given command (build, run, test or clean), creating its bin, data and log directories
+func (m *Makefile) targetEnv(c *Component, command string) ([]string, error) {
+	dataDir := m.dataDir(c)
+	logFile := m.logFile(c)
+
+	if err := m.ensureBinDir(c); err != nil {
+		return nil, errors.Wrap(err, "failed to ensureBinDir")
+	}
+
+	for _, dir := range []string{dataDir, filepath.Dir(logFile)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Wrapf(err, "failed to MkdirAll %s", dir)
+		}
+	}
+
+	env := []string{
+		fmt.Sprintf("BIN_DEST=%s", m.binDest(c)),
+		fmt.Sprintf("BIN_DIR=%s", m.binDir(c)),
+		fmt.Sprintf("MAKEUP_ROOT=%s", m.Root()),
+		fmt.Sprintf("MAKEUP_COMPONENT=%s", c.Name),
+		fmt.Sprintf("MAKEUP_COMPONENT_DIR=%s", m.absDir(c)),
+		fmt.Sprintf("MAKEUP_BIN_DIR=%s", m.binBase()),
+		fmt.Sprintf("MAKEUP_DATA_DIR=%s", dataDir),
+		fmt.Sprintf("MAKEUP_LOG_FILE=%s", logFile),
+		fmt.Sprintf("MAKEUP_COMMAND=%s", command),
+		fmt.Sprintf("MAKEUP_PROFILE=%s", m.Profile),
+	}
+
+	if m.binPathEnabled(c) {
+		env = append(env, m.binPathVar())
+	}
+
+	if m.Cover {
+		coverEnv, err := m.coverEnv(c)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to coverEnv")
+		}
+
+		env = append(env, coverEnv...)
+	}
+
+	return env, nil
+}
+
+// ProjectEnv returns the variables that makeup provides outside of any component, for `makeup shell`
+func (m *Makefile) ProjectEnv() []string {
+	env := []string{
+		fmt.Sprintf("MAKEUP_ROOT=%s", m.Root()),
+		fmt.Sprintf("MAKEUP_BIN_DIR=%s", m.binBase()),
+		fmt.Sprintf("MAKEUP_PROFILE=%s", m.Profile),
+	}
+
+	if m.BinPath != "off" {
+		env = append(env, m.binPathVar())
+	}
+
+	return env
+}
+
+// binPathEnabled returns true if the component's PATH should include every component's BIN_DIR
+func (m *Makefile) binPathEnabled(c *Component) bool {
+	if c.BinPath != "" {
+		return c.BinPath == "on"
+	}
+
+	return m.BinPath != "off"
+}
+
+// binPathVar returns a PATH variable with each component's BIN_DIR (where their binaries and artifacts
+// are) and then .bin added to the front of makeup's own PATH
+func (m *Makefile) binPathVar() string {
+	dirs := []string{}
+
+	for _, c := range m.all {
+		dirs = append(dirs, m.binDir(c))
+	}
+
+	dirs = append(dirs, m.binBase())
+
+	if path := os.Getenv("PATH"); path != "" {
+		dirs = append(dirs, path)
+	}
+
+	return "PATH=" + strings.Join(dirs, string(os.PathListSeparator))
+}
+
+// isHermetic returns true if the component should not inherit makeup's environment. A nil component
+// is the project itself, for hooks.
+func (m *Makefile) isHermetic(c *Component) bool {
+	return m.Hermetic || (c != nil && c.Hermetic)
+}
+
+// inherited returns the variables from makeup's environment that the component's targets receive,
+// which is all of them unless the component is hermetic
+func (m *Makefile) inherited(c *Component) []string {
+	if !m.isHermetic(c) {
+		return os.Environ()
+	}
+
+	allowed := append(append([]string{}, hermeticAllowlist...), m.PassEnv...)
+	if c != nil {
+		allowed = append(allowed, c.PassEnv...)
+	}
+
+	inherited := []string{}
+
+	for _, e := range os.Environ() {
+		if containsString(allowed, envName(e)) {
+			inherited = append(inherited, e)
+		}
+	}
+
+	return inherited
+}
+
+// Environ returns the complete environment that the component's run target receives
+func (m *Makefile) Environ(ctx context.Context, c *Component) ([]string, error) {
+	env, err := m.runEnv(ctx, c, "run")
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeEnv(m.inherited(c), env), nil
+}
+
+// EnvDiff compares makeup's environment to the one that the component's run target receives, returning
+// `- KEY=VALUE` lines for variables that the component doesn't get and `+ KEY=VALUE` lines for ones that
+// it gets instead, sorted by name
+func (m *Makefile) EnvDiff(ctx context.Context, c *Component) ([]string, error) {
+	environ, err := m.Environ(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	before := envMap(os.Environ())
+	after := envMap(environ)
+
+	names := []string{}
+	for name := range before {
+		names = append(names, name)
+	}
+
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	diff := []string{}
+
+	for _, name := range names {
+		old, hadOld := before[name]
+		updated, hasUpdated := after[name]
+
+		if hadOld && hasUpdated && old == updated {
+			continue
+		}
+
+		if hadOld {
+			diff = append(diff, "- "+name+"="+old)
+		}
+
+		if hasUpdated {
+			diff = append(diff, "+ "+name+"="+updated)
+		}
+	}
+
+	return diff, nil
+}
+
+// mergeEnv returns base with the values from env added, replacing any with the same name
+func mergeEnv(base, env []string) []string {
+	replaced := envMap(env)
+
+	merged := []string{}
+
+	for _, e := range base {
+		if _, ok := replaced[envName(e)]; !ok {
+			merged = append(merged, e)
+		}
+	}
+
+	return append(merged, env...)
+}
+
+func envMap(env []string) map[string]string {
+	m := map[string]string{}
+
+	for _, e := range env {
+		m[envName(e)] = strings.TrimPrefix(e, envName(e)+"=")
+	}
+
+	return m
+}
+
+// envName returns the name of a KEY=VALUE variable
+func envName(e string) string {
+	if eq := strings.Index(e, "="); eq >= 0 {
+		return e[:eq]
+	}
+
+	return e
+}
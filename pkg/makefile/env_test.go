@@ -0,0 +1,154 @@
+package makefile
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEnviron(t *testing.T) {
+	mk := writeProject(t, map[string]string{
+		"main.mk": "#makeup: passenv GLOBAL_VAR\n\n#makeup: hermetic\n#makeup: passenv A_VAR\ninclude ./a/a.mk\ninclude ./b/b.mk\n",
+		"a/a.mk":  "env:\n\t@echo FOO=component\n",
+		"b/b.mk":  "env:\n\t@echo FOO=component\n",
+	})
+
+	t.Setenv("HOME", "/home/me")
+	t.Setenv("TERM", "xterm")
+	t.Setenv("GLOBAL_VAR", "global")
+	t.Setenv("A_VAR", "a")
+	t.Setenv("UNRELATED", "shell")
+	t.Setenv("FOO", "shell")
+	t.Setenv("BIN_DEST", "/somewhere/else")
+
+	tests := []struct {
+		component string
+		want      map[string]string
+		missing   []string
+	}{
+		{
+			component: "a",
+			want: map[string]string{
+				"HOME":             "/home/me",
+				"TERM":             "xterm",
+				"GLOBAL_VAR":       "global",
+				"A_VAR":            "a",
+				"FOO":              "component",
+				"MAKEUP_COMPONENT": "a",
+			},
+			missing: []string{"UNRELATED"},
+		},
+		{
+			component: "b",
+			want: map[string]string{
+				"UNRELATED":        "shell",
+				"A_VAR":            "a",
+				"FOO":              "component",
+				"MAKEUP_COMPONENT": "b",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.component, func(t *testing.T) {
+			c := mk.Component(tt.component)
+
+			env, err := mk.Environ(context.Background(), c)
+			if err != nil {
+				t.Fatalf("Environ: %s", err)
+			}
+
+			counts := map[string]int{}
+			for _, e := range env {
+				counts[envName(e)]++
+			}
+
+			vars := envMap(env)
+
+			for name, value := range tt.want {
+				if vars[name] != value {
+					t.Errorf("got %s=%q, want %q", name, vars[name], value)
+				}
+			}
+
+			for _, name := range tt.missing {
+				if _, ok := vars[name]; ok {
+					t.Errorf("got %s, want it left out", name)
+				}
+			}
+
+			// the variables makeup sets replace inherited ones rather than being added alongside them
+			for _, name := range []string{"PATH", "FOO", "BIN_DEST"} {
+				if counts[name] != 1 {
+					t.Errorf("got %d %s variables, want 1", counts[name], name)
+				}
+			}
+
+			if vars["BIN_DEST"] != mk.binDest(c) {
+				t.Errorf("got BIN_DEST=%q, want %q", vars["BIN_DEST"], mk.binDest(c))
+			}
+		})
+	}
+}
+
+func TestInherited(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("GLOBAL_VAR", "global")
+	t.Setenv("A_VAR", "a")
+	t.Setenv("UNRELATED", "shell")
+
+	a := &Component{Name: "a", PassEnv: []string{"A_VAR"}}
+
+	tests := []struct {
+		name      string
+		m         *Makefile
+		component *Component
+		want      []string
+		missing   []string
+	}{
+		{
+			name:      "not hermetic",
+			m:         &Makefile{},
+			component: a,
+			want:      []string{"PATH", "GLOBAL_VAR", "A_VAR", "UNRELATED"},
+		},
+		{
+			name:      "hermetic project",
+			m:         &Makefile{Hermetic: true, PassEnv: []string{"GLOBAL_VAR"}},
+			component: a,
+			want:      []string{"PATH", "GLOBAL_VAR", "A_VAR"},
+			missing:   []string{"UNRELATED"},
+		},
+		{
+			name:      "hermetic hooks",
+			m:         &Makefile{Hermetic: true, PassEnv: []string{"GLOBAL_VAR"}},
+			component: nil,
+			want:      []string{"PATH", "GLOBAL_VAR"},
+			missing:   []string{"A_VAR", "UNRELATED"},
+		},
+		{
+			name:      "hermetic component",
+			m:         &Makefile{},
+			component: &Component{Name: "b", Hermetic: true},
+			want:      []string{"PATH"},
+			missing:   []string{"GLOBAL_VAR", "A_VAR", "UNRELATED"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := envMap(tt.m.inherited(tt.component))
+
+			for _, name := range tt.want {
+				if _, ok := vars[name]; !ok {
+					t.Errorf("got no %s, want it inherited", name)
+				}
+			}
+
+			for _, name := range tt.missing {
+				if _, ok := vars[name]; ok {
+					t.Errorf("got %s, want it left out", name)
+				}
+			}
+		})
+	}
+}
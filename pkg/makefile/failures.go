@@ -0,0 +1,206 @@
+package makefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cohix/makeup/pkg/exec"
+	"github.com/pkg/errors"
+)
+
+// outputTailLines is how many lines from the end of a failed target's output are kept to report
+const outputTailLines = 20
+
+// TargetError is a component's target that failed, with what's needed to report why
+type TargetError struct {
+	Component string
+	Target    string
+	// Command is the last command run for the target, which is the one that failed
+	Command string
+	// ExitCode is -1 if the command didn't exit by itself, such as when it was stopped or timed out
+	ExitCode int
+	Duration time.Duration
+	// Output is the end of the target's output
+	Output []string
+	Err    error
+}
+
+func (t *TargetError) Error() string {
+	return t.Err.Error()
+}
+
+func (t *TargetError) Unwrap() error {
+	return t.Err
+}
+
+// Report renders the failure as a block of text for the end of makeup's output
+func (t *TargetError) Report() string {
+	status := t.Err.Error()
+	if t.ExitCode >= 0 {
+		status = fmt.Sprintf("exit %d", t.ExitCode)
+	}
+
+	report := strings.Builder{}
+
+	fmt.Fprintf(&report, "%s of %s failed after %s (%s)\n", t.Target, t.Component, t.Duration.Round(time.Millisecond), status)
+
+	if t.Command != "" {
+		fmt.Fprintf(&report, "  command: %s\n", t.Command)
+	}
+
+	if len(t.Output) > 0 {
+		report.WriteString("  output:\n")
+
+		for _, l := range t.Output {
+			fmt.Fprintf(&report, "    %s\n", l)
+		}
+	}
+
+	return report.String()
+}
+
+// newTargetError creates a TargetError for the component's invocation, keeping the end of its output
+func newTargetError(component string, inv *Invocation, output string, duration time.Duration, err error) *TargetError {
+	t := &TargetError{
+		Component: component,
+		Target:    inv.Target,
+		Command:   inv.lastCommand,
+		ExitCode:  exec.ExitCode(err),
+		Duration:  duration,
+		Output:    tailLines(output),
+		Err:       err,
+	}
+
+	return t
+}
+
+// tailLines returns the last lines of output to report
+func tailLines(output string) []string {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return nil
+	}
+
+	lines := strings.Split(output, "\n")
+	if len(lines) > outputTailLines {
+		lines = lines[len(lines)-outputTailLines:]
+	}
+
+	return lines
+}
+
+// CheckError is a `# check` that failed
+type CheckError struct {
+	Check Check
+	Err   error
+}
+
+func (c *CheckError) Error() string {
+	return c.Err.Error()
+}
+
+func (c *CheckError) Unwrap() error {
+	return c.Err
+}
+
+// failure is a component whose target failed
+type failure struct {
+	component string
+	err       error
+}
+
+// failures collects the components that fail while a command runs across the project
+type failures struct {
+	lock sync.Mutex
+	list []failure
+}
+
+func (f *failures) add(component string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.list = append(f.list, failure{component: component, err: err})
+}
+
+// ComponentsError is returned once the components that failed have been reported, and unwraps to the
+// first failure. Since the report already describes each failure, its message only names the components.
+type ComponentsError struct {
+	Command    string
+	Components []string
+	first      error
+}
+
+func (c *ComponentsError) Error() string {
+	if len(c.Components) == 1 {
+		return fmt.Sprintf("%s failed to %s", c.Components[0], c.Command)
+	}
+
+	return fmt.Sprintf("%d components failed to %s", len(c.Components), c.Command)
+}
+
+func (c *ComponentsError) Unwrap() error {
+	return c.first
+}
+
+// summarize prints a report of each failed component followed by a list of them with their exit
+// statuses, returning a *ComponentsError if there were any
+func (f *failures) summarize(command string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.list) == 0 {
+		return nil
+	}
+
+	for _, fail := range f.list {
+		var targetErr *TargetError
+		if errors.As(fail.err, &targetErr) {
+			fmt.Println()
+			fmt.Print(targetErr.Report())
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("failed:")
+
+	for _, fail := range f.list {
+		fmt.Printf("  %s: %s\n", fail.component, exitStatus(fail.err))
+	}
+
+	components := []string{}
+	for _, fail := range f.list {
+		components = append(components, fail.component)
+	}
+
+	return &ComponentsError{Command: command, Components: components, first: f.list[0].err}
+}
+
+// forEach calls fn for each component, stopping at the first failure unless KeepGoing is set, and
+// summarizes the failures at the end
+func (m *Makefile) forEach(ctx context.Context, command string, fn func(c *Component) error) error {
+	failed := &failures{}
+
+	for _, c := range m.Components {
+		if err := fn(c); err != nil {
+			failed.add(c.Name, err)
+
+			if !m.KeepGoing || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+
+	return failed.summarize(command)
+}
+
+// exitStatus describes how a failed command ended
+func exitStatus(err error) string {
+	if code := exec.ExitCode(err); code >= 0 {
+		return fmt.Sprintf("exit %d", code)
+	}
+
+	return err.Error()
+}
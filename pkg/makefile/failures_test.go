@@ -0,0 +1,95 @@
+package makefile
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []string
+		want       string
+	}{
+		{name: "none"},
+		{name: "one", components: []string{"api"}, want: "api failed to build"},
+		{name: "several", components: []string{"api", "worker"}, want: "2 components failed to build"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failed := &failures{}
+
+			for _, c := range tt.components {
+				failed.add(c, &TargetError{Component: c, Target: "build", ExitCode: 2, Err: errors.New("exit status 2")})
+			}
+
+			err := failed.summarize("build")
+
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("got %v, want nil", err)
+				}
+
+				return
+			}
+
+			var componentsErr *ComponentsError
+			if !errors.As(err, &componentsErr) || err.Error() != tt.want {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+
+			// the exit code comes from the first failure
+			var targetErr *TargetError
+			if !errors.As(err, &targetErr) || targetErr.Component != tt.components[0] {
+				t.Errorf("got %v, want the first TargetError", err)
+			}
+		})
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepGoing bool
+		want      string
+	}{
+		{name: "stops at the first failure", want: "a"},
+		{name: "keep going", keepGoing: true, want: "a c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mk := writeProject(t, map[string]string{
+				"main.mk": "include ./a/a.mk ./b/b.mk ./c/c.mk\n",
+				"a/a.mk":  "build:\n\t@exit 2\n",
+				"b/b.mk":  "build:\n\t@touch built\n",
+				"c/c.mk":  "build:\n\t@exit 3\n",
+			})
+
+			mk.KeepGoing = tt.keepGoing
+
+			err := mk.BuildAll(context.Background())
+
+			var componentsErr *ComponentsError
+			if !errors.As(err, &componentsErr) || strings.Join(componentsErr.Components, " ") != tt.want {
+				t.Fatalf("got %v, want %s to have failed", err, tt.want)
+			}
+
+			// the exit code is the first failure's
+			var targetErr *TargetError
+			if !errors.As(err, &targetErr) || targetErr.Component != "a" || targetErr.ExitCode != 2 {
+				t.Errorf("got %v, want a's exit 2", err)
+			}
+
+			_, statErr := os.Stat(filepath.Join(mk.Root(), "b", "built"))
+			if built := statErr == nil; built != tt.keepGoing {
+				t.Errorf("got b built %t, want %t", built, tt.keepGoing)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package makefile
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// hookPrefix begins the names of main.mk targets that are run as hooks
+const hookPrefix = "hook/"
+
+// hooks are the points in the project's lifecycle that main.mk can run a hook/<name> target at
+var hooks = []string{"pre-build", "post-build", "pre-up", "post-up", "pre-down"}
+
+// runHook runs main.mk's hook/<name> target with the project's env, if it defines one
+func (m *Makefile) runHook(ctx context.Context, name string) error {
+	if !containsString(m.Hooks, name) {
+		return nil
+	}
+
+	fmt.Println("running hook:", name)
+
+	inv := &Invocation{
+		Target: hookPrefix + name,
+		Env:    m.ProjectEnv(),
+		Make:   m.Make,
+	}
+
+	if m.isHermetic(nil) {
+		inv.Env = mergeEnv(m.inherited(nil), inv.Env)
+		inv.Hermetic = true
+	}
+
+	if _, err := runMake(ctx, m.FullPath, "", nil, inv); err != nil {
+		return errors.Wrapf(err, "failed to run hook %s", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package makefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// integrationTarget is run from each component that defines it, and from main.mk, by TestIntegration
+const integrationTarget = "integration"
+
+// mainComponent names main.mk in test results and failure reports
+const mainComponent = "main.mk"
+
+// TestIntegration builds the project and runs it, and once its tasks have succeeded, its components are
+// ready and its post-up hook has succeeded, runs the integration target of each component that has one
+// and then main.mk's, each with the same env as the component's run target. The project is stopped once
+// the tests finish, and a result is returned for every component (with those without an integration
+// target skipped) along with any error.
+func (m *Makefile) TestIntegration(ctx context.Context) ([]*TestResult, error) {
+	if m.Cover {
+		for _, c := range m.Components {
+			if err := m.resetCoverage(c); err != nil {
+				return nil, errors.Wrap(err, "failed to resetCoverage")
+			}
+		}
+	}
+
+	if err := m.BuildAll(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to BuildAll")
+	}
+
+	var results []*TestResult
+	var testErr error
+	tested := false
+
+	runErr := m.runAll(ctx, func(ctx context.Context) error {
+		results, testErr = m.integrationTests(ctx)
+		tested = true
+
+		return nil
+	})
+
+	if !tested {
+		if runErr == nil {
+			runErr = errors.New("the project stopped before it was up")
+		}
+
+		return nil, errors.Wrap(runErr, "failed to start the project")
+	}
+
+	if testErr != nil {
+		return results, testErr
+	}
+
+	if runErr != nil {
+		return results, errors.Wrap(runErr, "failed to run the project")
+	}
+
+	return results, nil
+}
+
+// integrationTests runs the integration targets, stopping at the first failure unless KeepGoing is set,
+// and prints a table of the results
+func (m *Makefile) integrationTests(ctx context.Context) ([]*TestResult, error) {
+	failed := &failures{}
+	results := []*TestResult{}
+
+	projectEnv := []string{}
+
+	for _, c := range m.Components {
+		env, err := m.runEnv(ctx, c, integrationTarget)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to runEnv %s", c.Name)
+		}
+
+		// main.mk's target gets the env of every component, with later ones winning any conflicts
+		projectEnv = append(projectEnv, componentVars(env)...)
+
+		if stopped(ctx, failed, m.KeepGoing) {
+			results = append(results, &TestResult{Component: c.Name, Status: TestSkipped})
+			continue
+		}
+
+		has, err := m.hasTarget(ctx, c, integrationTarget)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read targets of %s", c.Name)
+		}
+
+		if !has {
+			results = append(results, &TestResult{Component: c.Name, Status: TestSkipped})
+			continue
+		}
+
+		fmt.Println("integration testing:", c.Name)
+
+		result, err := m.runTest(ctx, c, integrationTarget, env, nil)
+		results = append(results, result)
+
+		if err != nil {
+			failed.add(c.Name, errors.Wrapf(err, "failed to test %s", c.Dir))
+			continue
+		}
+
+		fmt.Println("integration test complete:", c.Name)
+	}
+
+	if m.Integration {
+		result := &TestResult{Component: mainComponent, Status: TestSkipped}
+
+		if !stopped(ctx, failed, m.KeepGoing) {
+			fmt.Println("integration testing:", mainComponent)
+
+			var err error
+			if result, err = m.runMainIntegration(ctx, projectEnv); err != nil {
+				failed.add(mainComponent, err)
+			} else {
+				fmt.Println("integration test complete:", mainComponent)
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	err := failed.summarize("test")
+
+	fmt.Println()
+	printTestTable(results)
+
+	if err == nil && ctx.Err() != nil {
+		err = errors.Wrap(ctx.Err(), "tests were stopped")
+	}
+
+	return results, err
+}
+
+// runMainIntegration runs main.mk's integration target with the project's env on top of the given one
+func (m *Makefile) runMainIntegration(ctx context.Context, env []string) (*TestResult, error) {
+	result := &TestResult{Component: mainComponent, Status: TestFailed}
+
+	inv := &Invocation{
+		Target: integrationTarget,
+		Env:    append(env, m.ProjectEnv()...),
+		Make:   m.Make,
+	}
+
+	if m.isHermetic(nil) {
+		inv.Env = mergeEnv(m.inherited(nil), inv.Env)
+		inv.Hermetic = true
+	}
+
+	start := time.Now()
+
+	output, err := runMake(ctx, m.FullPath, "", nil, inv)
+
+	result.Duration = time.Since(start)
+	result.Output = output
+
+	if err != nil {
+		return result, newTargetError(mainComponent, inv, output, result.Duration, err)
+	}
+
+	result.Status = TestPassed
+
+	return result, nil
+}
+
+// hasTarget returns true if the component defines the target or main.mk overrides it
+func (m *Makefile) hasTarget(ctx context.Context, c *Component, target string) (bool, error) {
+	if m.ContainsOverride(c.Name, target) {
+		return true, nil
+	}
+
+	targets, err := c.Driver.Targets(ctx, &Invocation{Component: c, Make: m.Make})
+	if err != nil {
+		return false, err
+	}
+
+	return containsString(targets, target), nil
+}
+
+// componentVars returns the variables from a component's env that are specific to it, leaving out the
+// ones makeup gives every component
+func componentVars(env []string) []string {
+	vars := []string{}
+
+	for _, e := range env {
+		name := envName(e)
+		if name == "PATH" || name == "BIN_DEST" || name == "BIN_DIR" || name == "GOCOVERDIR" || strings.HasPrefix(name, "MAKEUP_") {
+			continue
+		}
+
+		vars = append(vars, e)
+	}
+
+	return vars
+}
+
+// stopped returns true if no more tests should run, because ctx is done or one failed without KeepGoing
+func stopped(ctx context.Context, failed *failures, keepGoing bool) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+
+	failed.lock.Lock()
+	defer failed.lock.Unlock()
+
+	return len(failed.list) > 0 && !keepGoing
+}
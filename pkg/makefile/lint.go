@@ -0,0 +1,157 @@
+package makefile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// lifecycleTargets are the targets every component must define
+var lifecycleTargets = []string{"build", "run", "test", "env", "clean"}
+
+// Severity is the seriousness of a Diagnostic
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem found while parsing or linting, positioned within a file
+type Diagnostic struct {
+	Pos      Pos
+	Severity Severity
+	Message  string
+}
+
+// String formats the diagnostic as file:line:col: severity: message
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Pos, d.Severity, d.Message)
+}
+
+// Lint parses the Makefile at the given path and validates it along with each of its components.
+// Problems with the project are returned as diagnostics, an error is only returned if linting could not happen.
+func Lint(ctx context.Context, path string) ([]Diagnostic, error) {
+	diags := []Diagnostic{}
+
+	addDiag := func(pos Pos, severity Severity, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{
+			Pos:      pos,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	mk, err := parseUnchecked(path)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			addDiag(parseErr.Pos, SeverityError, parseErr.Msg)
+			return diags, nil
+		}
+
+		return nil, err
+	}
+
+	diags = append(diags, mk.Warnings...)
+
+	// maps component names to the position they were first included at
+	components := map[string]Pos{}
+	all := []*Component{}
+
+	for _, declared := range mk.Components {
+		if _, err := os.Stat(declared.source()); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				if declared.Optional {
+					continue
+				}
+
+				if declared.Extern != "" {
+					addDiag(declared.Pos, SeverityError, "missing %s from extern %s", declared.source(), declared.Extern)
+				} else {
+					addDiag(declared.Pos, SeverityError, "missing %s", declared.source())
+				}
+			} else {
+				addDiag(declared.Pos, SeverityError, "failed to Stat %s: %s", declared.source(), err.Error())
+			}
+
+			continue
+		}
+
+		if len(declared.MakeArgs) > 0 && declared.Driver.Name() != makeDriverName {
+			addDiag(declared.Pos, SeverityWarning, "makeargs has no effect on %s, which does not use make", declared.source())
+		}
+
+		expanded := []*Component{declared}
+
+		if exp, ok := declared.Driver.(expander); ok {
+			expanded, err = exp.Expand(declared)
+			if err != nil {
+				addDiag(declared.Pos, SeverityError, "failed to read components of %s: %s", declared.File, err.Error())
+				continue
+			}
+		}
+
+		all = append(all, expanded...)
+
+		for _, c := range expanded {
+			if first, exists := components[c.Name]; exists {
+				addDiag(c.Pos, SeverityError, "duplicate component name %s (first included at %s), add a '#makeup: name' line before one of them to rename it", c.Name, first)
+			} else {
+				components[c.Name] = c.Pos
+			}
+
+			targets, err := c.Driver.Targets(ctx, &Invocation{Component: c, Make: mk.Make})
+			if err != nil {
+				addDiag(c.Pos, SeverityError, "failed to read targets of %s: %s", c.source(), err.Error())
+				continue
+			}
+
+			for _, t := range lifecycleTargets {
+				if containsString(targets, t) || mk.ContainsOverride(c.Name, t) {
+					continue
+				}
+
+				addDiag(c.Pos, SeverityError, "%s is missing the %s target", c.source(), t)
+			}
+		}
+	}
+
+	var parseErr *ParseError
+	if err := checkAfter(all); errors.As(err, &parseErr) {
+		addDiag(parseErr.Pos, SeverityError, parseErr.Msg)
+	}
+
+	for _, o := range mk.Overrides {
+		if _, ok := components[o.Component]; !ok {
+			addDiag(o.Pos, SeverityError, "override for unknown component %s", o.Component)
+		}
+
+		if !isLifecycleTarget(o.Target) {
+			addDiag(o.Pos, SeverityError, "override for %s/%s is not one of the targets %s", o.Component, o.Target, strings.Join(append(append([]string{}, lifecycleTargets...), optionalTargets...), ", "))
+		}
+	}
+
+	return diags, nil
+}
+
+// optionalTargets are the targets makeup runs if a component defines them
+var optionalTargets = []string{integrationTarget, readyTarget}
+
+// isLifecycleTarget returns true if makeup runs the target, which includes the optional ones
+func isLifecycleTarget(target string) bool {
+	return containsString(lifecycleTargets, target) || containsString(optionalTargets, target)
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+package makefile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cohix/makeup/pkg/exec"
+	"github.com/pkg/errors"
+)
+
+// MakeConfig configures how targets from Makefiles are run
+type MakeConfig struct {
+	// Native runs Makefiles with makeup's built-in interpreter, falling back to make for those it can't run
+	Native bool
+	// Bin is the make binary to use, which defaults to $MAKE or make
+	Bin string
+	// Args are passed to every make invocation, such as VAR=value or -j 4
+	Args []string
+}
+
+// Binary returns the make binary that will be run
+func (mc MakeConfig) Binary() string {
+	if mc.Bin != "" {
+		return mc.Bin
+	}
+
+	if env := os.Getenv("MAKE"); env != "" {
+		return env
+	}
+
+	return "make"
+}
+
+// Verify checks that the make binary is GNU make, which makeup relies on
+func (mc MakeConfig) Verify(ctx context.Context) error {
+	out, err := exec.New(mc.Binary(), "--version").Run(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to run %s --version", mc.Binary())
+	}
+
+	if !strings.Contains(out, "GNU Make") {
+		return fmt.Errorf("%s is not GNU make, set MAKE or --make to a GNU make binary (such as gmake)", mc.Binary())
+	}
+
+	return nil
+}
+
+// args returns the args to make that run the target of the Makefile at path with the given flags,
+// followed by the configured args and then any extra args
+func (mc MakeConfig) args(path, target, flags string, extra []string) []string {
+	args := []string{flags, "-f", path}
+	args = append(args, mc.Args...)
+	args = append(args, extra...)
+	args = append(args, target)
+
+	return args
+}
+
+// runMake runs the invocation's target from the Makefile at path, using the native interpreter if it
+// is enabled and falling back to the system make if the Makefile is too complex for it
+func runMake(ctx context.Context, path, dir string, extra []string, inv *Invocation) (string, error) {
+	var unsupported *unsupportedError
+
+	if inv.Make.Native {
+		vars := append(append([]string{}, inv.Make.Args...), extra...)
+
+		out, err := runNative(ctx, path, dir, vars, inv)
+		if !errors.As(err, &unsupported) {
+			return out, err
+		}
+	}
+
+	out, err := inv.command(dir, inv.Make.Binary(), inv.Make.args(path, inv.Target, "-s", extra)...).Run(ctx)
+	if err != nil && unsupported != nil {
+		return out, errors.Wrapf(err, "failed to run make (%s)", unsupported.Error())
+	}
+
+	return out, err
+}
@@ -1,10 +1,12 @@
 package makefile
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -12,51 +14,119 @@ import (
 )
 
 const (
-	includePrefix = "include "
-	checkPrefix   = "# check "
-	equalPrefix   = "# equal "
-	externPrefix  = "# extern "
-	rootPrefix    = "# root "
-	overrideLine  = "# override"
+	checkDirective    = "check"
+	equalDirective    = "equal"
+	externDirective   = "extern"
+	overrideDirective = "override"
+	goDirective       = "go"
+	includeDirective  = "include"
+	driverDirective   = "driver"
+	makeargsDirective = "makeargs"
+	timeoutDirective  = "timeout"
+	hermeticDirective = "hermetic"
+	passenvDirective  = "passenv"
+	nameDirective     = "name"
+	artifactDirective = "artifact"
+	binpathDirective  = "binpath"
+	taskDirective     = "task"
+	afterDirective    = "after"
+	onExitDirective   = "on-exit"
+	testJSONDirective = "test-json"
 )
 
+// DefaultProfile is the profile that components run with unless another is chosen
+const DefaultProfile = "default"
+
 // Makefile is a lightly-parsed Makefile
 type Makefile struct {
-	Checks    []Check
-	Includes  []include
-	Overrides []override
+	Checks     []Check
+	Components []*Component
+	Overrides  []override
+	Warnings   []Diagnostic
+	// Hooks are the names of the hook/<name> targets defined in main.mk
+	Hooks []string
+	// Integration is set if main.mk defines an integration target
+	Integration bool
+
+	// Timeouts limit how long each target may run for across all components, from global `#makeup: timeout` directives
+	Timeouts map[string]time.Duration
+	// Hermetic stops every component from inheriting makeup's environment, from a global `#makeup: hermetic` directive
+	Hermetic bool
+	// PassEnv are the variables that hermetic components inherit and that test results are cached against,
+	// from global `#makeup: passenv` directives
+	PassEnv []string
+	// BinPath is "off" if a global `#makeup: binpath off` directive stops components' BIN_DIRs being added to PATH
+	BinPath string
+	// OnExit is what happens when a component's run target exits, from a global `#makeup: on-exit` directive
+	OnExit string
 
 	FullPath string
-}
 
-// include represents an `include` statement in a Makefile, plus optional `extern` modifier
-type include struct {
-	Path   string
-	Extern string
+	// all is every component of the project, which stays the same if Components is narrowed down
+	all []*Component
+
+	// Make configures how targets from Makefiles are run, and is set by the caller after parsing
+	Make MakeConfig
+	// Profile is passed to components as MAKEUP_PROFILE, and is set by the caller after parsing
+	Profile string
+	// KeepGoing carries on with the other components after one fails, and FailFast stops everything when
+	// one does. They are set by the caller after parsing, and otherwise each command has its own default.
+	KeepGoing bool
+	FailFast  bool
+	// Jobs is how many components' tests run at once, NoCache stops passing results being reused, and
+	// Retries is how many more times a failed test target runs. They are set by the caller after parsing.
+	Jobs    int
+	NoCache bool
+	Retries int
+	// Cover gives each component's targets somewhere to write Go coverage, and is set by the caller after parsing
+	Cover bool
 }
 
 // override represents an overridden target for a component
 type override struct {
 	Component string
 	Target    string
+	Pos       Pos
 }
 
 // Parse reads and parses the Makefile at the given path
 func Parse(path string) (*Makefile, error) {
-	file, err := os.Open(path)
+	mk, err := parseUnchecked(path)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to Open %s", path)
+		return nil, err
+	}
+
+	if err := mk.ensureComponents(); err != nil {
+		return nil, errors.Wrap(err, "failed to ensureComponents")
+	}
+
+	if err := mk.expandComponents(); err != nil {
+		return nil, errors.Wrap(err, "failed to expandComponents")
 	}
 
-	defer file.Close()
+	if err := mk.checkNames(); err != nil {
+		return nil, err
+	}
+
+	if err := checkAfter(mk.Components); err != nil {
+		return nil, err
+	}
 
-	mk, err := parse(file)
+	mk.all = mk.Components
+
+	return mk, nil
+}
+
+// parseUnchecked parses the Makefile at the given path without validating its components
+func parseUnchecked(path string) (*Makefile, error) {
+	file, err := ParseAST(path)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to parse %s", path)
+		return nil, errors.Wrapf(err, "failed to ParseAST %s", path)
 	}
 
-	if err := mk.ensureIncludes(); err != nil {
-		return nil, errors.Wrap(err, "failed to ensureIncludes")
+	mk, err := fromAST(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
 	}
 
 	fullPath, err := filepath.Abs(path)
@@ -70,16 +140,36 @@ func Parse(path string) (*Makefile, error) {
 }
 
 // TestChecks runs each defined Check and returns an error if any fail
-func (m *Makefile) TestChecks() error {
+func (m *Makefile) TestChecks(ctx context.Context) error {
 	for _, c := range m.Checks {
-		out, err := exec.RunSilent(c.Cmd, "")
-		if err != nil {
-			return errors.Wrapf(err, "failed to RunSilent %s", c.Cmd)
+		if err := m.testCheck(ctx, c); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
+
+// testCheck runs a Check, limited by the project's check timeout if there is one
+func (m *Makefile) testCheck(ctx context.Context, c Check) error {
+	timeout := m.Timeouts["check"]
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-		if !strings.Contains(out, c.Equals) {
-			return fmt.Errorf("failed check: %s is not %s, got %s", c.Cmd, c.Equals, out)
+	out, err := exec.RunSilentContext(ctx, c.Cmd, "")
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return &CheckError{Check: c, Err: fmt.Errorf("check %s timed out after %s", c.Cmd, timeout)}
 		}
+
+		return &CheckError{Check: c, Err: errors.Wrapf(err, "failed to RunSilent %s", c.Cmd)}
+	}
+
+	if !strings.Contains(out, c.Equals) {
+		return &CheckError{Check: c, Err: fmt.Errorf("failed check: %s is not %s, got %s", c.Cmd, c.Equals, out)}
 	}
 
 	return nil
@@ -96,114 +186,457 @@ func (m *Makefile) ContainsOverride(component, target string) bool {
 	return false
 }
 
-func parse(file *os.File) (*Makefile, error) {
-	mk := &Makefile{
-		Checks:   []Check{},
-		Includes: []include{},
+// UsesMake returns true if running the project's components runs make, because one of them is a Makefile,
+// main.mk overrides one of their targets or defines hooks, or integration is set and main.mk has an
+// integration target
+func (m *Makefile) UsesMake(integration bool) bool {
+	if len(m.Hooks) > 0 || (integration && m.Integration) {
+		return true
 	}
 
-	scn := newScanner(file)
+	for _, c := range m.Components {
+		if c.Driver.Name() == makeDriverName {
+			return true
+		}
 
-	for {
-		line, err := scn.readLine()
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to readLine")
+		for _, o := range m.Overrides {
+			if o.Component == c.Name {
+				return true
+			}
 		}
+	}
 
-		if len(line) == 0 {
-			break
+	return false
+}
+
+// Component returns the named component, or nil if there is none
+func (m *Makefile) Component(name string) *Component {
+	for _, c := range m.Components {
+		if c.Name == name {
+			return c
 		}
+	}
 
-		if strings.HasPrefix(line, checkPrefix) {
-			check := Check{
-				Cmd: strings.TrimPrefix(line, checkPrefix),
-			}
+	return nil
+}
 
-			nextLine, err := scn.readLine()
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to readLine")
-			}
+// fromAST interprets the directives and includes of a parsed File
+func fromAST(file *File) (*Makefile, error) {
+	mk := &Makefile{
+		Checks:     []Check{},
+		Components: []*Component{},
+		Overrides:  []override{},
+		Warnings:   file.Warnings,
+		Hooks:      []string{},
+		Timeouts:   map[string]time.Duration{},
+		Profile:    DefaultProfile,
+	}
 
-			if !strings.HasPrefix(nextLine, equalPrefix) {
-				return nil, fmt.Errorf("line following check is not an 'equal' value (got %s)", nextLine)
-			}
+	nodes := file.Nodes
 
-			check.Equals = strings.TrimPrefix(nextLine, equalPrefix)
+	// modifiers apply to the next component declared
+	pending := []*Directive{}
 
-			mk.Checks = append(mk.Checks, check)
-		} else if strings.HasPrefix(line, includePrefix) {
-			includePath := strings.TrimPrefix(line, includePrefix)
+	for i := 0; i < len(nodes); i++ {
+		if len(pending) > 0 && !isBlank(nodes[i]) && !isModifier(nodes[i]) && !declaresComponent(nodes[i]) {
+			last := pending[len(pending)-1]
+			return nil, &ParseError{Pos: nodes[i].Pos(), Msg: fmt.Sprintf("line following %s is not an 'include' statement", last.Name)}
+		}
 
-			incl := include{
-				Path: includePath,
-			}
+		switch node := nodes[i].(type) {
+		case *Include:
+			components := []*Component{}
 
-			mk.Includes = append(mk.Includes, incl)
-		} else if strings.HasPrefix(line, externPrefix) {
-			externPath := strings.TrimPrefix(line, externPrefix)
+			for _, p := range node.Paths {
+				c := newFileComponent(p.Path, nil, p.Pos)
+				c.Optional = node.Optional()
 
-			includeLine, err := scn.readLine()
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to readLine")
+				components = append(components, c)
 			}
 
-			if !strings.HasPrefix(includeLine, includePrefix) {
-				return nil, fmt.Errorf("line following extern is not an 'include' statement (got %s)", includeLine)
+			if err := applyModifiers(components, pending); err != nil {
+				return nil, err
 			}
 
-			includePath := strings.TrimPrefix(includeLine, includePrefix)
-
-			incl := include{
-				Path:   includePath,
-				Extern: externPath,
+			for _, c := range components {
+				if c.Driver.Name() != makeDriverName {
+					return nil, &ParseError{Pos: c.Pos, Msg: fmt.Sprintf("%s is not a Makefile, use '#makeup: include %s' so that make does not include it", c.File, c.File)}
+				}
 			}
 
-			mk.Includes = append(mk.Includes, incl)
-		} else if line == overrideLine {
-			targetLine, err := scn.readLine()
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to readLine")
+			mk.Components = append(mk.Components, components...)
+			pending = []*Directive{}
+		case *Comment:
+			// commenting out an include disables it, which is easy to mistake for declaring a component
+			if text := strings.TrimSpace(strings.TrimLeft(node.Text, "#")); isInclude(text) {
+				mk.Warnings = append(mk.Warnings, Diagnostic{
+					Pos:      node.Pos(),
+					Severity: SeverityWarning,
+					Message:  fmt.Sprintf("commented-out include of %s is ignored (use '#makeup: include' for components that aren't Makefiles)", strings.TrimSpace(strings.TrimPrefix(text, firstWord(text)))),
+				})
 			}
+		case *Rule:
+			for _, t := range node.Targets {
+				if t == integrationTarget {
+					mk.Integration = true
+				}
+
+				if !strings.HasPrefix(t, hookPrefix) {
+					continue
+				}
 
-			if !strings.Contains(targetLine, ":") {
-				return nil, fmt.Errorf("line following override is not a target (got %s)", targetLine)
+				if name := strings.TrimPrefix(t, hookPrefix); containsString(hooks, name) {
+					mk.Hooks = append(mk.Hooks, name)
+				} else {
+					mk.Warnings = append(mk.Warnings, Diagnostic{
+						Pos:      node.Pos(),
+						Severity: SeverityWarning,
+						Message:  fmt.Sprintf("unknown hook %s, which makeup will not run (hooks are %s)", t, strings.Join(hooks, ", ")),
+					})
+				}
 			}
+		case *Directive:
+			switch node.Name {
+			case checkDirective:
+				next := nextNonBlank(nodes, i)
+
+				equal, ok := nodeAt(nodes, next).(*Directive)
+				if !ok || equal.Name != equalDirective {
+					return nil, &ParseError{Pos: posAfter(nodes, node, next), Msg: "line following check is not an 'equal' value"}
+				}
+
+				check := Check{
+					Cmd:    node.Value,
+					Equals: equal.Value,
+					Pos:    node.Pos(),
+				}
+
+				mk.Checks = append(mk.Checks, check)
+
+				i = next
+			case equalDirective:
+				return nil, &ParseError{Pos: node.Pos(), Msg: "equal does not follow a check"}
+			case externDirective, driverDirective, makeargsDirective, timeoutDirective, hermeticDirective, passenvDirective, nameDirective, artifactDirective, binpathDirective, taskDirective, afterDirective, onExitDirective, testJSONDirective:
+				if globalDirectives[node.Name] && !modifiesComponent(nodes, i) {
+					if err := mk.applyGlobal(node); err != nil {
+						return nil, err
+					}
+
+					continue
+				}
+
+				pending = append(pending, node)
+			case includeDirective, goDirective:
+				if node.Value == "" {
+					return nil, &ParseError{Pos: node.Pos(), Msg: fmt.Sprintf("%s directive is missing a path", node.Name)}
+				}
+
+				c := newGoComponent(node.Value, node.ValuePos)
+				if node.Name == includeDirective {
+					c = newFileComponent(node.Value, nil, node.ValuePos)
+				}
+
+				if err := applyModifiers([]*Component{c}, pending); err != nil {
+					return nil, err
+				}
+
+				mk.Components = append(mk.Components, c)
+				pending = []*Directive{}
+			case overrideDirective:
+				next := nextNonBlank(nodes, i)
 
-			fullTarget := targetLine[:strings.Index(targetLine, ":")]
-			targetParts := strings.Split(fullTarget, "/")
-			if len(targetParts) != 2 {
-				return nil, fmt.Errorf("override targed must have two /-seperated parts (got %d)", len(targetParts))
+				rule, ok := nodeAt(nodes, next).(*Rule)
+				if !ok {
+					return nil, &ParseError{Pos: posAfter(nodes, node, next), Msg: "line following override is not a target"}
+				}
+
+				if len(rule.Targets) != 1 {
+					return nil, &ParseError{Pos: rule.Pos(), Msg: fmt.Sprintf("override must define exactly one target (got %d)", len(rule.Targets))}
+				}
+
+				targetParts := strings.Split(rule.Targets[0], "/")
+				if len(targetParts) != 2 {
+					return nil, &ParseError{Pos: rule.Pos(), Msg: fmt.Sprintf("override target must have two /-separated parts (got %d)", len(targetParts))}
+				}
+
+				ovr := override{
+					Component: targetParts[0],
+					Target:    targetParts[1],
+					Pos:       rule.Pos(),
+				}
+
+				mk.Overrides = append(mk.Overrides, ovr)
+
+				i = next
 			}
+		}
+	}
 
-			component := targetParts[0]
-			target := targetParts[1]
+	if len(pending) > 0 {
+		last := pending[len(pending)-1]
+		return nil, &ParseError{Pos: last.Pos(), Msg: fmt.Sprintf("%s is not followed by an 'include' statement", last.Name)}
+	}
+
+	return mk, nil
+}
+
+// applyModifiers applies modifier directives to the components declared after them
+func applyModifiers(components []*Component, modifiers []*Directive) error {
+	for _, mod := range modifiers {
+		for _, c := range components {
+			switch mod.Name {
+			case externDirective:
+				c.Extern = mod.Value
+			case driverDirective:
+				driver, ok := drivers[mod.Value]
+				if !ok {
+					return &ParseError{Pos: mod.ValuePos, Msg: fmt.Sprintf("unknown driver %s", mod.Value)}
+				}
 
-			ovr := override{
-				Component: component,
-				Target:    target,
+				c.Driver = driver
+			case makeargsDirective:
+				c.MakeArgs = append(c.MakeArgs, strings.Fields(mod.Value)...)
+			case timeoutDirective:
+				target, timeout, err := parseTimeout(mod, false)
+				if err != nil {
+					return err
+				}
+
+				if c.Timeouts == nil {
+					c.Timeouts = map[string]time.Duration{}
+				}
+
+				c.Timeouts[target] = timeout
+			case hermeticDirective:
+				c.Hermetic = true
+			case passenvDirective:
+				c.PassEnv = append(c.PassEnv, strings.Fields(mod.Value)...)
+			case nameDirective:
+				if len(components) > 1 || strings.ContainsAny(mod.Value, " \t/") || mod.Value == "" {
+					return &ParseError{Pos: mod.ValuePos, Msg: "name must be a single word applied to a single component"}
+				}
+
+				c.Name = mod.Value
+			case artifactDirective:
+				c.Artifacts = append(c.Artifacts, strings.Fields(mod.Value)...)
+			case binpathDirective:
+				if err := checkOnOff(mod); err != nil {
+					return err
+				}
+
+				c.BinPath = mod.Value
+			case taskDirective:
+				c.Task = true
+			case afterDirective:
+				if mod.Value == "" {
+					return &ParseError{Pos: mod.ValuePos, Msg: "after must name the tasks to run after"}
+				}
+
+				c.After = append(c.After, strings.Fields(mod.Value)...)
+			case onExitDirective:
+				if err := checkOnExit(mod); err != nil {
+					return err
+				}
+
+				c.OnExit = mod.Value
+			case testJSONDirective:
+				c.TestJSON = true
 			}
+		}
+	}
 
-			mk.Overrides = append(mk.Overrides, ovr)
+	for _, c := range components {
+		if c.Task && c.OnExit != "" {
+			return &ParseError{Pos: c.Pos, Msg: fmt.Sprintf("on-exit does not apply to %s, since it is a task", c.Name)}
+		}
+
+		if _, ok := c.Driver.(expander); ok && c.Name != componentName(c.File) {
+			return &ParseError{Pos: c.Pos, Msg: fmt.Sprintf("%s can't be renamed with a name line, its components are named after its entries", c.File)}
 		}
 	}
 
-	return mk, nil
+	return nil
 }
 
-func (m *Makefile) ensureIncludes() error {
-	for _, incl := range m.Includes {
-		if _, err := os.Stat(incl.Path); err != nil {
+// applyGlobal applies a directive that isn't followed by a component to the whole project
+func (m *Makefile) applyGlobal(d *Directive) error {
+	switch d.Name {
+	case timeoutDirective:
+		target, timeout, err := parseTimeout(d, true)
+		if err != nil {
+			return err
+		}
+
+		m.Timeouts[target] = timeout
+	case hermeticDirective:
+		m.Hermetic = true
+	case passenvDirective:
+		m.PassEnv = append(m.PassEnv, strings.Fields(d.Value)...)
+	case binpathDirective:
+		if err := checkOnOff(d); err != nil {
+			return err
+		}
+
+		m.BinPath = d.Value
+	case onExitDirective:
+		if err := checkOnExit(d); err != nil {
+			return err
+		}
+
+		m.OnExit = d.Value
+	}
+
+	return nil
+}
+
+// checkOnExit returns an error if the directive's value isn't one of the on-exit policies
+func checkOnExit(d *Directive) error {
+	if !containsString(onExitPolicies, d.Value) {
+		return &ParseError{Pos: d.ValuePos, Msg: fmt.Sprintf("on-exit must be one of %s", strings.Join(onExitPolicies, ", "))}
+	}
+
+	return nil
+}
+
+// checkOnOff returns an error if the directive's value isn't on or off
+func checkOnOff(d *Directive) error {
+	if d.Value != "on" && d.Value != "off" {
+		return &ParseError{Pos: d.ValuePos, Msg: fmt.Sprintf("%s must be on or off", d.Name)}
+	}
+
+	return nil
+}
+
+// parseTimeout parses the `<target> <duration>` value of a `#makeup: timeout` directive. Global timeouts
+// can also apply to `check`.
+func parseTimeout(d *Directive, global bool) (string, time.Duration, error) {
+	fields := strings.Fields(d.Value)
+	if len(fields) != 2 {
+		return "", 0, &ParseError{Pos: d.ValuePos, Msg: "timeout must be a target and a duration, such as 'build 5m'"}
+	}
+
+	if !isLifecycleTarget(fields[0]) && !(global && fields[0] == "check") {
+		return "", 0, &ParseError{Pos: d.ValuePos, Msg: fmt.Sprintf("timeout for unknown target %s", fields[0])}
+	}
+
+	timeout, err := time.ParseDuration(fields[1])
+	if err != nil || timeout <= 0 {
+		return "", 0, &ParseError{Pos: d.ValuePos, Msg: fmt.Sprintf("invalid timeout duration %s", fields[1])}
+	}
+
+	return fields[0], timeout, nil
+}
+
+// modifiesComponent returns true if the directive at index i is directly followed by a component
+// declaration, with only other modifiers (and no blank lines) in between
+func modifiesComponent(nodes []Node, i int) bool {
+	next := i + 1
+
+	for isModifier(nodeAt(nodes, next)) {
+		next++
+	}
+
+	return declaresComponent(nodeAt(nodes, next))
+}
+
+// declaresComponent returns true if the node is an include or a directive that declares a component
+func declaresComponent(n Node) bool {
+	switch node := n.(type) {
+	case *Include:
+		return true
+	case *Directive:
+		return node.Name == includeDirective || node.Name == goDirective
+	}
+
+	return false
+}
+
+// isModifier returns true if the node is a directive that applies to the next component
+func isModifier(n Node) bool {
+	d, ok := n.(*Directive)
+
+	return ok && modifierDirectives[d.Name]
+}
+
+func isBlank(n Node) bool {
+	_, ok := n.(*Blank)
+
+	return ok
+}
+
+// nextNonBlank returns the index of the first non-Blank node after i, or len(nodes) if there is none
+func nextNonBlank(nodes []Node, i int) int {
+	next := i + 1
+
+	for next < len(nodes) {
+		if _, ok := nodes[next].(*Blank); !ok {
+			break
+		}
+
+		next++
+	}
+
+	return next
+}
+
+// nodeAt returns the node at index i, or nil if i is out of range
+func nodeAt(nodes []Node, i int) Node {
+	if i < len(nodes) {
+		return nodes[i]
+	}
+
+	return nil
+}
+
+// posAfter returns the position of the node at index next, or that of the current node if there are no more
+func posAfter(nodes []Node, current Node, next int) Pos {
+	if n := nodeAt(nodes, next); n != nil {
+		return n.Pos()
+	}
+
+	return current.Pos()
+}
+
+// checkNames checks that no two components share a name, which would also mean sharing a BIN_DIR
+func (m *Makefile) checkNames() error {
+	first := map[string]Pos{}
+
+	for _, c := range m.Components {
+		if pos, exists := first[c.Name]; exists {
+			return &ParseError{Pos: c.Pos, Msg: fmt.Sprintf("duplicate component name %s (first included at %s), add a '#makeup: name' line before one of them to rename it", c.Name, pos)}
+		}
+
+		first[c.Name] = c.Pos
+	}
+
+	return nil
+}
+
+// ensureComponents checks that every component's files exist, dropping optional includes that do not
+func (m *Makefile) ensureComponents() error {
+	existing := []*Component{}
+
+	for _, c := range m.Components {
+		if _, err := os.Stat(c.source()); err != nil {
 			if errors.Is(err, os.ErrNotExist) {
-				if incl.Extern != "" {
-					return errors.Wrapf(err, "missing %s from extern %s", incl.Path, incl.Extern)
+				if c.Optional {
+					continue
+				}
+
+				if c.Extern != "" {
+					return errors.Wrapf(err, "%s: missing %s from extern %s", c.Pos, c.source(), c.Extern)
 				} else {
-					return errors.Wrap(err, "missing %s")
+					return errors.Wrapf(err, "%s: missing %s", c.Pos, c.source())
 				}
 			}
 
-			return errors.Wrapf(err, "failed to Stat %s", incl.Path)
+			return errors.Wrapf(err, "failed to Stat %s", c.source())
 		}
+
+		existing = append(existing, c)
 	}
 
+	m.Components = existing
+
 	return nil
 }
@@ -0,0 +1,118 @@
+package makefile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFromAST(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        string
+		components []string
+		tasks      []string
+		warnings   int
+	}{
+		{
+			name:       "includes",
+			src:        "include ./a/a.mk ./b/b.mk\n",
+			components: []string{"a", "b"},
+		},
+		{
+			name:       "commented out include",
+			src:        "# include ./b/b.mk\ninclude ./a/a.mk\n",
+			components: []string{"a"},
+			warnings:   1,
+		},
+		{
+			name:       "commented out directive include",
+			src:        "# #makeup: include ./web/package.json\ninclude ./a/a.mk\n",
+			components: []string{"a"},
+		},
+		{
+			name:       "prose before an include",
+			src:        "# task runner for the whole repo\ninclude ./a/a.mk\n",
+			components: []string{"a"},
+		},
+		{
+			name:       "task",
+			src:        "#makeup: task\ninclude ./a/a.mk\n\n#makeup: after a\ninclude ./b/b.mk\n",
+			components: []string{"a", "b"},
+			tasks:      []string{"a"},
+		},
+		{
+			name:       "directive include",
+			src:        "#makeup: include ./web/package.json\n",
+			components: []string{"web"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseAST("main.mk", strings.NewReader(tt.src))
+			if err != nil {
+				t.Fatalf("parseAST: %s", err)
+			}
+
+			mk, err := fromAST(f)
+			if err != nil {
+				t.Fatalf("fromAST: %s", err)
+			}
+
+			names, tasks := []string{}, []string{}
+			for _, c := range mk.Components {
+				names = append(names, c.Name)
+
+				if c.Task {
+					tasks = append(tasks, c.Name)
+				}
+			}
+
+			if strings.Join(names, " ") != strings.Join(tt.components, " ") {
+				t.Errorf("got components %v, want %v", names, tt.components)
+			}
+
+			if strings.Join(tasks, " ") != strings.Join(tt.tasks, " ") {
+				t.Errorf("got tasks %v, want %v", tasks, tt.tasks)
+			}
+
+			if len(mk.Warnings) != tt.warnings {
+				t.Errorf("got %d warnings, want %d: %v", len(mk.Warnings), tt.warnings, mk.Warnings)
+			}
+		})
+	}
+}
+
+func TestUsesMake(t *testing.T) {
+	tests := []struct {
+		name        string
+		src         string
+		integration bool
+		want        bool
+	}{
+		{name: "makefile component", src: "include ./a/a.mk\n", want: true},
+		{name: "go component", src: "#makeup: go ./svc\n"},
+		{name: "override", src: "#makeup: go ./svc\n\n# override\nsvc/build:\n\t@true\n", want: true},
+		{name: "hook", src: "#makeup: go ./svc\n\nhook/pre-build:\n\t@true\n", want: true},
+		{name: "integration target when testing", src: "#makeup: go ./svc\n\nintegration:\n\t@true\n", integration: true, want: true},
+		{name: "integration target otherwise", src: "#makeup: go ./svc\n\nintegration:\n\t@true\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseAST("main.mk", strings.NewReader(tt.src))
+			if err != nil {
+				t.Fatalf("parseAST: %s", err)
+			}
+
+			mk, err := fromAST(f)
+			if err != nil {
+				t.Fatalf("fromAST: %s", err)
+			}
+
+			if got := mk.UsesMake(tt.integration); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
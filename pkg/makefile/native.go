@@ -0,0 +1,558 @@
+package makefile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxExpansionDepth guards against recursively defined variables
+const maxExpansionDepth = 64
+
+// unsupportedVars are variables that make defines itself, which the native interpreter can't provide.
+// MAKE in particular runs a sub-make, which needs the system make.
+var unsupportedVars = map[string]bool{
+	"MAKE":          true,
+	"MAKEFLAGS":     true,
+	"MAKECMDGOALS":  true,
+	"MAKEFILE_LIST": true,
+	"MAKELEVEL":     true,
+	"MFLAGS":        true,
+}
+
+// unsupportedError is returned by the native interpreter when a Makefile uses something it can't run,
+// in which case the system make is used instead
+type unsupportedError struct {
+	Pos  Pos
+	What string
+}
+
+func (u *unsupportedError) Error() string {
+	msg := fmt.Sprintf("%s is not supported by the native make interpreter", u.What)
+
+	if u.Pos.File != "" {
+		return fmt.Sprintf("%s: %s", u.Pos, msg)
+	}
+
+	return msg
+}
+
+// nativeVar is a make variable, which is expanded when used if it is recursive
+type nativeVar struct {
+	value     string
+	recursive bool
+	// override is set for variables from the command line, which assignments in the Makefile do not change
+	override bool
+}
+
+// nativeRule is a target's prerequisites and recipe, merged from every rule that mentions it
+type nativeRule struct {
+	prereqs []string
+	recipe  []string
+}
+
+// nativeMake is a minimal make interpreter for simple Makefiles, supporting variables, `${VAR}`
+// expansion, rules with prerequisites, recipes with `@`/`-`/`+` prefixes and includes. Recipes are run
+// with sh and are not echoed, as with `make -s`.
+type nativeMake struct {
+	ctx   context.Context
+	inv   *Invocation
+	dir   string
+	vars  map[string]nativeVar
+	rules map[string]*nativeRule
+	phony map[string]bool
+	made  map[string]bool
+}
+
+// runNative runs the invocation's target from the Makefile at path (relative to dir) natively, returning
+// an *unsupportedError without running anything if the Makefile can't be interpreted. Every recipe that
+// the target could run is checked before any of them are, so that falling back to the system make never
+// runs a recipe twice. VAR=value entries of args override the Makefile's variables as they would on the
+// make command line, and other args (such as -j) are ignored.
+func runNative(ctx context.Context, path, dir string, args []string, inv *Invocation) (string, error) {
+	n := &nativeMake{
+		ctx:   ctx,
+		inv:   inv,
+		dir:   dir,
+		vars:  map[string]nativeVar{},
+		rules: map[string]*nativeRule{},
+		phony: map[string]bool{},
+		made:  map[string]bool{},
+	}
+
+	environ := inv.Env
+	if !inv.Hermetic {
+		environ = append(os.Environ(), inv.Env...)
+	}
+
+	// like make, the environment is available as variables
+	for _, e := range environ {
+		if eq := strings.Index(e, "="); eq > 0 {
+			n.vars[e[:eq]] = nativeVar{value: e[eq+1:]}
+		}
+	}
+
+	curdir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to filepath.Abs")
+	}
+
+	n.vars["CURDIR"] = nativeVar{value: curdir}
+
+	for _, a := range args {
+		if eq := strings.Index(a, "="); eq > 0 && !strings.HasPrefix(a, "-") {
+			n.vars[a[:eq]] = nativeVar{value: a[eq+1:], override: true}
+		}
+	}
+
+	if err := n.load(n.resolve(path), false); err != nil {
+		return "", err
+	}
+
+	if err := n.check(inv.Target, map[string]bool{}); err != nil {
+		return "", err
+	}
+
+	out, err := n.make(inv.Target)
+
+	// recipes may have run by now, so the system make must not run them again
+	var unsupported *unsupportedError
+	if errors.As(err, &unsupported) {
+		return out, fmt.Errorf("failed to run natively after recipes had started: %s", unsupported.Error())
+	}
+
+	return out, err
+}
+
+// check expands the recipe of the target and those of its prerequisites, returning an error if any of them
+// use something that the native interpreter can't run
+func (n *nativeMake) check(target string, checked map[string]bool) error {
+	if checked[target] {
+		return nil
+	}
+
+	checked[target] = true
+
+	rule, ok := n.rules[target]
+	if !ok {
+		return nil
+	}
+
+	for _, p := range rule.prereqs {
+		if err := n.check(p, checked); err != nil {
+			return err
+		}
+	}
+
+	auto := n.autoVars(target, rule)
+
+	for _, line := range rule.recipe {
+		if _, err := n.expand(line, auto, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// autoVars returns the automatic variables for the target's recipe
+func (n *nativeMake) autoVars(target string, rule *nativeRule) map[string]string {
+	auto := map[string]string{
+		"@": target,
+		"^": strings.Join(uniqueStrings(rule.prereqs), " "),
+		"<": "",
+	}
+
+	if len(rule.prereqs) > 0 {
+		auto["<"] = rule.prereqs[0]
+	}
+
+	return auto
+}
+
+// resolve returns a path relative to the directory make runs in
+func (n *nativeMake) resolve(path string) string {
+	if filepath.IsAbs(path) || n.dir == "" {
+		return path
+	}
+
+	return filepath.Join(n.dir, path)
+}
+
+func (n *nativeMake) load(path string, optional bool) error {
+	file, err := ParseAST(path)
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return errors.Wrapf(err, "failed to ParseAST %s", path)
+	}
+
+	for _, node := range file.Nodes {
+		switch node := node.(type) {
+		case *Blank, *Comment, *Directive:
+			continue
+		case *Assignment:
+			if err := n.assign(node); err != nil {
+				return err
+			}
+		case *Rule:
+			if err := n.addRule(node); err != nil {
+				return err
+			}
+		case *Include:
+			for _, p := range node.Paths {
+				included, err := n.expand(p.Path, nil, 0)
+				if err != nil {
+					return err
+				}
+
+				if err := n.load(n.resolve(included), node.Optional()); err != nil {
+					return err
+				}
+			}
+		default:
+			return &unsupportedError{Pos: node.Pos(), What: fmt.Sprintf("%q", strings.TrimSpace(node.Raw()[0]))}
+		}
+	}
+
+	return nil
+}
+
+func (n *nativeMake) assign(a *Assignment) error {
+	if strings.ContainsAny(a.Name, " \t$") {
+		return &unsupportedError{Pos: a.Pos(), What: fmt.Sprintf("assignment to %q", a.Name)}
+	}
+
+	existing, exists := n.vars[a.Name]
+	if existing.override {
+		return nil
+	}
+
+	switch a.Op {
+	case "=":
+		n.vars[a.Name] = nativeVar{value: a.Value, recursive: true}
+	case ":=", "::=":
+		value, err := n.expand(a.Value, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		n.vars[a.Name] = nativeVar{value: value}
+	case "?=":
+		if !exists {
+			n.vars[a.Name] = nativeVar{value: a.Value, recursive: true}
+		}
+	case "+=":
+		value := a.Value
+
+		if !existing.recursive {
+			expanded, err := n.expand(a.Value, nil, 0)
+			if err != nil {
+				return err
+			}
+
+			value = expanded
+		}
+
+		if exists && existing.value != "" {
+			value = existing.value + " " + value
+		}
+
+		n.vars[a.Name] = nativeVar{value: value, recursive: existing.recursive || !exists}
+	default:
+		return &unsupportedError{Pos: a.Pos(), What: fmt.Sprintf("the %s operator", a.Op)}
+	}
+
+	return nil
+}
+
+func (n *nativeMake) addRule(r *Rule) error {
+	targets := []string{}
+
+	for _, t := range r.Targets {
+		expanded, err := n.expand(t, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		targets = append(targets, strings.Fields(expanded)...)
+	}
+
+	prereqs := []string{}
+
+	for _, p := range r.Prereqs {
+		if strings.Contains(p, "=") {
+			return &unsupportedError{Pos: r.Pos(), What: "a target-specific variable"}
+		}
+
+		expanded, err := n.expand(p, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range strings.Fields(expanded) {
+			// order-only prerequisites are treated as normal ones
+			if e != "|" {
+				prereqs = append(prereqs, e)
+			}
+		}
+	}
+
+	for _, t := range targets {
+		switch {
+		case t == ".PHONY":
+			for _, p := range prereqs {
+				n.phony[p] = true
+			}
+
+			continue
+		case t == ".SILENT":
+			continue
+		case strings.HasPrefix(t, "."):
+			return &unsupportedError{Pos: r.Pos(), What: fmt.Sprintf("the special target %s", t)}
+		case strings.Contains(t, "%"):
+			return &unsupportedError{Pos: r.Pos(), What: "a pattern rule"}
+		}
+
+		rule, ok := n.rules[t]
+		if !ok {
+			rule = &nativeRule{}
+			n.rules[t] = rule
+		}
+
+		rule.prereqs = append(rule.prereqs, prereqs...)
+
+		// as with make, a later recipe for the same target replaces an earlier one
+		if len(r.Recipe) > 0 {
+			rule.recipe = r.Recipe
+		}
+	}
+
+	return nil
+}
+
+// make brings the target up to date, returning the output of every recipe run
+func (n *nativeMake) make(target string) (string, error) {
+	if n.made[target] {
+		return "", nil
+	}
+
+	n.made[target] = true
+
+	rule, ok := n.rules[target]
+	if !ok {
+		if _, err := os.Stat(n.resolve(target)); err == nil {
+			return "", nil
+		}
+
+		return "", fmt.Errorf("no rule to make target '%s'", target)
+	}
+
+	output := strings.Builder{}
+
+	for _, p := range rule.prereqs {
+		prereqOut, err := n.make(p)
+		output.WriteString(prereqOut)
+
+		if err != nil {
+			return output.String(), err
+		}
+	}
+
+	if !n.outdated(target, rule) {
+		return output.String(), nil
+	}
+
+	auto := n.autoVars(target, rule)
+
+	for _, line := range rule.recipe {
+		expanded, err := n.expand(line, auto, 0)
+		if err != nil {
+			return output.String(), err
+		}
+
+		cmd, ignoreErr := recipePrefixes(expanded)
+		if cmd == "" {
+			continue
+		}
+
+		cmdOut, err := n.inv.shell(n.dir, cmd).Run(n.ctx)
+		output.WriteString(cmdOut)
+
+		if err != nil && !ignoreErr {
+			return output.String(), errors.Wrapf(err, "recipe for target '%s' failed", target)
+		}
+	}
+
+	return output.String(), nil
+}
+
+// outdated returns true if the target's recipe needs to run
+func (n *nativeMake) outdated(target string, rule *nativeRule) bool {
+	if n.phony[target] {
+		return true
+	}
+
+	info, err := os.Stat(n.resolve(target))
+	if err != nil {
+		return true
+	}
+
+	for _, p := range rule.prereqs {
+		prereqInfo, err := os.Stat(n.resolve(p))
+		if err != nil || prereqInfo.ModTime().After(info.ModTime()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expand expands variable references in s, using auto for automatic variables such as $@
+func (n *nativeMake) expand(s string, auto map[string]string, depth int) (string, error) {
+	if depth > maxExpansionDepth {
+		return "", fmt.Errorf("variable expansion is too deep in %q", s)
+	}
+
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	out := strings.Builder{}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+
+		next := s[i+1]
+
+		var name string
+
+		switch next {
+		case '$':
+			out.WriteByte('$')
+			i++
+
+			continue
+		case '(', '{':
+			end := matchingParen(s, i+1)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+
+			inner, err := n.expand(s[i+2:end], auto, depth+1)
+			if err != nil {
+				return "", err
+			}
+
+			if strings.ContainsAny(inner, " \t:,=") {
+				return "", &unsupportedError{What: fmt.Sprintf("the function or substitution reference $(%s)", inner)}
+			}
+
+			name = inner
+			i = end
+		default:
+			name = string(next)
+			i++
+		}
+
+		value, err := n.lookup(name, auto, depth)
+		if err != nil {
+			return "", err
+		}
+
+		out.WriteString(value)
+	}
+
+	return out.String(), nil
+}
+
+func (n *nativeMake) lookup(name string, auto map[string]string, depth int) (string, error) {
+	if value, ok := auto[name]; ok {
+		return value, nil
+	}
+
+	if unsupportedVars[name] {
+		return "", &unsupportedError{What: fmt.Sprintf("$(%s)", name)}
+	}
+
+	// other automatic variables such as $* and $(@D)
+	if len(name) == 1 && strings.Contains("*?+|%", name) || len(name) == 2 && strings.ContainsAny(name[:1], "@^<*?+|%") && strings.ContainsAny(name[1:], "DF") {
+		return "", &unsupportedError{What: fmt.Sprintf("the automatic variable $(%s)", name)}
+	}
+
+	v, ok := n.vars[name]
+	if !ok {
+		return "", nil
+	}
+
+	if !v.recursive {
+		return v.value, nil
+	}
+
+	return n.expand(v.value, auto, depth+1)
+}
+
+// matchingParen returns the index of the paren closing the one at open, or -1
+func matchingParen(s string, open int) int {
+	opening := s[open]
+	closing := byte(')')
+	if opening == '{' {
+		closing = '}'
+	}
+
+	depth := 0
+
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case opening:
+			depth++
+		case closing:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// recipePrefixes strips the `@`, `-` and `+` prefixes from a recipe line, returning whether errors should be ignored
+func recipePrefixes(line string) (string, bool) {
+	ignoreErr := false
+
+	line = strings.TrimLeft(line, " \t")
+
+	for len(line) > 0 && strings.ContainsAny(line[:1], "@-+") {
+		if line[0] == '-' {
+			ignoreErr = true
+		}
+
+		line = strings.TrimLeft(line[1:], " \t")
+	}
+
+	return line, ignoreErr
+}
+
+func uniqueStrings(list []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+
+	for _, l := range list {
+		if !seen[l] {
+			seen[l] = true
+			unique = append(unique, l)
+		}
+	}
+
+	return unique
+}
@@ -0,0 +1,164 @@
+package makefile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeMakefile writes a Makefile with the given contents to a new directory, returning the directory
+func writeMakefile(t *testing.T, contents string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "Makefile"), []byte(contents), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	return dir
+}
+
+func nativeInvocation(target string) *Invocation {
+	return &Invocation{Target: target, Out: &bytes.Buffer{}, Make: MakeConfig{Native: true}}
+}
+
+func TestRunNative(t *testing.T) {
+	tests := []struct {
+		name     string
+		makefile string
+		target   string
+		args     []string
+		want     string
+	}{
+		{
+			name:     "prerequisites run first",
+			makefile: ".PHONY: all dep\nall: dep\n\t@echo all\ndep:\n\t@echo dep\n",
+			target:   "all",
+			want:     "dep\nall\n",
+		},
+		{
+			name:     "variables",
+			makefile: "A = $(B) two\nB := one\nC ?= three\nC += four\nall:\n\t@echo $(A) ${C}\n",
+			target:   "all",
+			want:     "one two three four\n",
+		},
+		{
+			name:     "command line variables override",
+			makefile: "A := file\nall:\n\t@echo $(A)\n",
+			target:   "all",
+			args:     []string{"A=args", "-j", "4"},
+			want:     "args\n",
+		},
+		{
+			name:     "automatic variables",
+			makefile: ".PHONY: all a b\nall: a b\n\t@echo $@ $< $^\na:\nb:\n",
+			target:   "all",
+			want:     "all a a b\n",
+		},
+		{
+			name:     "ignored errors",
+			makefile: "all:\n\t-@false\n\t@echo after\n",
+			target:   "all",
+			want:     "after\n",
+		},
+		{
+			name:     "escaped dollar",
+			makefile: "all:\n\t@echo $$((1 + 1))\n",
+			target:   "all",
+			want:     "2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeMakefile(t, tt.makefile)
+
+			out, err := runNative(context.Background(), "Makefile", dir, tt.args, nativeInvocation(tt.target))
+			if err != nil {
+				t.Fatalf("runNative: %s", err)
+			}
+
+			if out != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunNativeCurdir(t *testing.T) {
+	dir := writeMakefile(t, "all:\n\t@echo $(CURDIR)\n")
+
+	out, err := runNative(context.Background(), "Makefile", dir, nil, nativeInvocation("all"))
+	if err != nil {
+		t.Fatalf("runNative: %s", err)
+	}
+
+	if strings.TrimSpace(out) != dir {
+		t.Errorf("got %q, want %q", out, dir)
+	}
+}
+
+func TestRunNativeUnsupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		makefile string
+	}{
+		{name: "function in a later recipe", makefile: "all: gen\n\t@echo $(shell echo hi)\n"},
+		{name: "function in a recursive variable", makefile: "V = $(wildcard *.go)\nall: gen\n\t@echo $(V)\n"},
+		{name: "sub-make", makefile: "all: gen\n\t$(MAKE) -C sub\n"},
+		{name: "unknown automatic variable", makefile: "all: gen\n\t@echo $*\n"},
+		{name: "directory automatic variable", makefile: "all: gen\n\t@echo $(@D)\n"},
+		{name: "conditional", makefile: "ifdef X\nendif\nall: gen\n"},
+		{name: "pattern rule", makefile: "%.o: %.c\nall: gen\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// gen appends to a file, which shows whether it ran
+			dir := writeMakefile(t, tt.makefile+".PHONY: gen\ngen:\n\t@echo ran >> gen.log\n")
+
+			_, err := runNative(context.Background(), "Makefile", dir, nil, nativeInvocation("all"))
+
+			var unsupported *unsupportedError
+			if !errors.As(err, &unsupported) {
+				t.Fatalf("got %v, want an unsupportedError", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "gen.log")); err == nil {
+				t.Errorf("a recipe ran before the Makefile was found to be unsupported")
+			}
+		})
+	}
+}
+
+func TestRunMakeFallbackRunsRecipesOnce(t *testing.T) {
+	if _, err := osexec.LookPath("make"); err != nil {
+		t.Skip("make is not installed")
+	}
+
+	dir := writeMakefile(t, ".PHONY: all gen\nall: gen\n\t@echo $(shell echo done)\ngen:\n\t@echo ran >> gen.log\n")
+
+	out, err := runMake(context.Background(), "Makefile", dir, nil, nativeInvocation("all"))
+	if err != nil {
+		t.Fatalf("runMake: %s", err)
+	}
+
+	if strings.TrimSpace(out) != "done" {
+		t.Errorf("got %q, want %q", out, "done\n")
+	}
+
+	log, err := os.ReadFile(filepath.Join(dir, "gen.log"))
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+
+	if string(log) != "ran\n" {
+		t.Errorf("gen ran %d times, want once", strings.Count(string(log), "ran"))
+	}
+}
@@ -0,0 +1,507 @@
+package makefile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// directivePrefix begins the comments that makeup interprets as directives, such as `#makeup: task`
+const directivePrefix = "#makeup:"
+
+// legacyDirectives are the directives that makeup also interprets when written as `# name value`, since
+// main.mk files used that form for them before the prefix existed
+var legacyDirectives = map[string]bool{
+	checkDirective:    true,
+	equalDirective:    true,
+	externDirective:   true,
+	overrideDirective: true,
+}
+
+// knownDirectives are the directives that makeup interprets
+var knownDirectives = map[string]bool{
+	checkDirective:    true,
+	equalDirective:    true,
+	externDirective:   true,
+	overrideDirective: true,
+	goDirective:       true,
+	includeDirective:  true,
+	driverDirective:   true,
+	makeargsDirective: true,
+	timeoutDirective:  true,
+	hermeticDirective: true,
+	passenvDirective:  true,
+	nameDirective:     true,
+	artifactDirective: true,
+	binpathDirective:  true,
+	taskDirective:     true,
+	afterDirective:    true,
+	onExitDirective:   true,
+	testJSONDirective: true,
+}
+
+// modifierDirectives are the directives that apply to the include that follows them
+var modifierDirectives = map[string]bool{
+	externDirective:   true,
+	driverDirective:   true,
+	makeargsDirective: true,
+	timeoutDirective:  true,
+	hermeticDirective: true,
+	passenvDirective:  true,
+	nameDirective:     true,
+	artifactDirective: true,
+	binpathDirective:  true,
+	taskDirective:     true,
+	afterDirective:    true,
+	onExitDirective:   true,
+	testJSONDirective: true,
+}
+
+// globalDirectives are modifier directives that apply to every component when they are not directly followed by one
+var globalDirectives = map[string]bool{
+	timeoutDirective:  true,
+	hermeticDirective: true,
+	passenvDirective:  true,
+	binpathDirective:  true,
+	onExitDirective:   true,
+}
+
+var includeKeywords = []string{"include", "-include", "sinclude"}
+
+var assignmentOps = []string{"::=", ":=", "?=", "+=", "!=", "="}
+
+// logicalLine is a line of a Makefile after backslash continuations are joined
+type logicalLine struct {
+	text string
+	raw  []string
+	line int
+}
+
+// ParseAST reads the Makefile at the given path and parses it into a File
+func ParseAST(path string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to Open %s", path)
+	}
+
+	defer file.Close()
+
+	return parseAST(path, file)
+}
+
+func parseAST(name string, rd io.Reader) (*File, error) {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ReadAll")
+	}
+
+	p := &parser{
+		file: &File{
+			Path:           name,
+			Nodes:          []Node{},
+			Warnings:       []Diagnostic{},
+			crlf:           bytes.Contains(data, []byte("\r\n")),
+			noFinalNewline: len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")),
+		},
+		lines: splitLogicalLines(string(data)),
+	}
+
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+
+	return p.file, nil
+}
+
+// splitLogicalLines splits the contents of a Makefile into lines, handling CRLF endings and `\` continuations
+func splitLogicalLines(data string) []logicalLine {
+	physical := strings.Split(data, "\n")
+
+	// a trailing newline does not start another line
+	if len(physical) > 0 && physical[len(physical)-1] == "" {
+		physical = physical[:len(physical)-1]
+	}
+
+	lines := []logicalLine{}
+
+	for i := 0; i < len(physical); i++ {
+		current := logicalLine{
+			text: strings.TrimSuffix(physical[i], "\r"),
+			line: i + 1,
+		}
+
+		current.raw = []string{current.text}
+
+		isRecipe := strings.HasPrefix(current.text, "\t")
+
+		for continues(current.text) && i+1 < len(physical) {
+			i++
+
+			next := strings.TrimSuffix(physical[i], "\r")
+			current.raw = append(current.raw, next)
+
+			if isRecipe {
+				// recipes keep their continuations for the shell, minus the leading tab
+				current.text = current.text + "\n" + strings.TrimPrefix(next, "\t")
+			} else {
+				joined := strings.TrimRight(strings.TrimSuffix(current.text, "\\"), " \t")
+				current.text = joined + " " + strings.TrimLeft(next, " \t")
+			}
+		}
+
+		lines = append(lines, current)
+	}
+
+	return lines
+}
+
+// continues returns true if the line ends in an unescaped backslash
+func continues(line string) bool {
+	count := 0
+
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		count++
+	}
+
+	return count%2 == 1
+}
+
+type parser struct {
+	file  *File
+	lines []logicalLine
+	idx   int
+}
+
+func (p *parser) parse() error {
+	for p.idx < len(p.lines) {
+		ll := p.lines[p.idx]
+		trimmed := strings.TrimSpace(ll.text)
+
+		var node Node
+		var err error
+
+		switch {
+		case trimmed == "":
+			node = &Blank{source: p.source(ll, 1)}
+		case strings.HasPrefix(ll.text, "\t"):
+			return &ParseError{Pos: p.pos(ll, 1), Msg: "recipe commences before first target"}
+		case strings.HasPrefix(trimmed, "#"):
+			node = p.parseComment(ll)
+		case isInclude(trimmed):
+			node, err = p.parseInclude(ll)
+		case firstWord(trimmed) == "define":
+			node = p.parseDefine(ll)
+		default:
+			node = p.parseStatement(ll)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		p.file.Nodes = append(p.file.Nodes, node)
+		p.idx++
+	}
+
+	return nil
+}
+
+func (p *parser) pos(ll logicalLine, col int) Pos {
+	return Pos{
+		File: p.file.Path,
+		Line: ll.line,
+		Col:  col,
+	}
+}
+
+func (p *parser) source(ll logicalLine, col int) source {
+	return source{
+		pos: p.pos(ll, col),
+		raw: ll.raw,
+	}
+}
+
+// parseComment parses a comment, which is a directive if it has the form `#makeup: name value`, or
+// `# name value` for one of the legacy directives. Any other comment is left alone, with a warning if it
+// looks like a directive written without the prefix.
+func (p *parser) parseComment(ll logicalLine) Node {
+	col := strings.Index(ll.text, "#") + 1
+	text := strings.TrimSpace(ll.text)
+
+	var body string
+
+	switch {
+	case strings.HasPrefix(text, directivePrefix):
+		body = strings.TrimSpace(strings.TrimPrefix(text, directivePrefix))
+
+		if name := firstWord(body); !knownDirectives[name] {
+			p.file.Warnings = append(p.file.Warnings, Diagnostic{
+				Pos:      p.pos(ll, col),
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("unknown directive %q", name),
+			})
+
+			return &Comment{source: p.source(ll, col), Text: text}
+		}
+	case strings.HasPrefix(text, "# ") && legacyDirectives[firstWord(strings.TrimPrefix(text, "# "))]:
+		body = strings.TrimPrefix(text, "# ")
+	default:
+		if strings.HasPrefix(text, "# ") {
+			body := strings.TrimPrefix(text, "# ")
+			name := firstWord(body)
+
+			if looksLikeDirective(name, strings.TrimSpace(strings.TrimPrefix(body, name))) {
+				p.file.Warnings = append(p.file.Warnings, Diagnostic{
+					Pos:      p.pos(ll, col),
+					Severity: SeverityWarning,
+					Message:  fmt.Sprintf("`# %s` is a comment, did you mean `#makeup: %s`?", name, name),
+				})
+			}
+		}
+
+		return &Comment{source: p.source(ll, col), Text: text}
+	}
+
+	name := firstWord(body)
+
+	value := strings.TrimSpace(strings.TrimPrefix(body, name))
+	valueCol := col
+
+	if value != "" {
+		valueCol = strings.Index(ll.text, value) + 1
+	}
+
+	d := &Directive{
+		source:   p.source(ll, col),
+		Name:     name,
+		Value:    value,
+		ValuePos: p.pos(ll, valueCol),
+	}
+
+	return d
+}
+
+// looksLikeDirective returns true if a `# name value` comment is probably a directive that's missing its
+// prefix, rather than prose that happens to start with a directive's name. Commented-out includes are
+// warned about once main.mk is read, since they're only a mistake for files that aren't Makefiles.
+func looksLikeDirective(name, value string) bool {
+	fields := strings.Fields(value)
+
+	switch name {
+	case taskDirective, hermeticDirective, testJSONDirective:
+		return value == ""
+	case nameDirective:
+		return len(fields) == 1 && componentNameRegex.MatchString(value)
+	case goDirective, artifactDirective:
+		return len(fields) > 0 && allMatch(fields, pathRegex)
+	case driverDirective:
+		_, ok := drivers[value]
+		return ok
+	case binpathDirective:
+		return value == "on" || value == "off"
+	case onExitDirective:
+		return containsString(onExitPolicies, value)
+	case timeoutDirective:
+		if len(fields) != 2 {
+			return false
+		}
+
+		_, err := time.ParseDuration(fields[1])
+		return err == nil
+	case makeargsDirective:
+		return len(fields) > 0 && allMatch(fields, makeArgRegex)
+	case passenvDirective:
+		return len(fields) > 0 && allMatch(fields, envNameRegex)
+	case afterDirective:
+		return len(fields) > 0 && allMatch(fields, componentNameRegex)
+	}
+
+	return false
+}
+
+var (
+	pathRegex          = regexp.MustCompile(`^[\w.-]*[./][\w./-]*$`)
+	makeArgRegex       = regexp.MustCompile(`^(-\S+|[A-Za-z_][A-Za-z0-9_]*=\S*)$`)
+	envNameRegex       = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+	componentNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// allMatch returns true if every one of the strings matches the regex
+func allMatch(list []string, regex *regexp.Regexp) bool {
+	for _, s := range list {
+		if !regex.MatchString(s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseInclude parses an include statement, which may list several paths
+func (p *parser) parseInclude(ll logicalLine) (Node, error) {
+	col := len(ll.text) - len(strings.TrimLeft(ll.text, " \t")) + 1
+	fields := strings.Fields(ll.text)
+
+	incl := &Include{
+		source:  p.source(ll, col),
+		Keyword: fields[0],
+		Paths:   []IncludePath{},
+	}
+
+	// find each path within the physical lines so that its position is accurate
+	rawLine, offset := 0, col-1+len(fields[0])
+
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, "#") {
+			break
+		}
+
+		for rawLine < len(ll.raw) && !strings.Contains(ll.raw[rawLine][offset:], f) {
+			rawLine++
+			offset = 0
+		}
+
+		pathPos := p.pos(ll, col)
+
+		if rawLine < len(ll.raw) {
+			index := offset + strings.Index(ll.raw[rawLine][offset:], f)
+
+			pathPos.Line = ll.line + rawLine
+			pathPos.Col = index + 1
+			offset = index + len(f)
+		}
+
+		incl.Paths = append(incl.Paths, IncludePath{Path: f, Pos: pathPos})
+	}
+
+	if len(incl.Paths) == 0 {
+		return nil, &ParseError{Pos: incl.pos, Msg: fmt.Sprintf("%s statement has no paths", incl.Keyword)}
+	}
+
+	return incl, nil
+}
+
+// parseDefine consumes a multi-line variable definition through its endef
+func (p *parser) parseDefine(ll logicalLine) Node {
+	other := &Other{source: p.source(ll, 1), Text: ll.text}
+
+	for p.idx+1 < len(p.lines) {
+		p.idx++
+
+		next := p.lines[p.idx]
+		other.raw = append(other.raw, next.raw...)
+		other.Text = other.Text + "\n" + next.text
+
+		if firstWord(strings.TrimSpace(next.text)) == "endef" {
+			break
+		}
+	}
+
+	return other
+}
+
+// parseStatement parses a rule (plus its recipe) or a variable assignment
+func (p *parser) parseStatement(ll logicalLine) Node {
+	text := stripComment(ll.text)
+
+	colon := strings.Index(text, ":")
+	equals := strings.Index(text, "=")
+
+	if equals >= 0 && (colon < 0 || equals < colon || isAssignmentOp(text[colon:])) {
+		for _, op := range assignmentOps {
+			if i := strings.Index(text, op); i >= 0 && i <= equals {
+				return &Assignment{
+					source: p.source(ll, 1),
+					Name:   strings.TrimSpace(text[:i]),
+					Op:     op,
+					Value:  strings.TrimSpace(text[i+len(op):]),
+				}
+			}
+		}
+	}
+
+	if colon < 0 {
+		return &Other{source: p.source(ll, 1), Text: ll.text}
+	}
+
+	rule := &Rule{
+		source:  p.source(ll, 1),
+		Targets: strings.Fields(text[:colon]),
+		Prereqs: []string{},
+		Recipe:  []string{},
+	}
+
+	prereqs := strings.TrimLeft(text[colon+1:], ":")
+
+	// an inline recipe follows a semicolon
+	if semi := strings.Index(prereqs, ";"); semi >= 0 {
+		rule.Recipe = append(rule.Recipe, strings.TrimSpace(prereqs[semi+1:]))
+		prereqs = prereqs[:semi]
+	}
+
+	rule.Prereqs = append(rule.Prereqs, strings.Fields(prereqs)...)
+
+	// consume recipe lines, including blank lines between them
+	for next := p.idx + 1; next < len(p.lines); next++ {
+		nextLine := p.lines[next]
+
+		if strings.TrimSpace(nextLine.text) == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(nextLine.text, "\t") {
+			break
+		}
+
+		for _, skipped := range p.lines[p.idx+1 : next] {
+			rule.raw = append(rule.raw, skipped.raw...)
+		}
+
+		rule.raw = append(rule.raw, nextLine.raw...)
+		rule.Recipe = append(rule.Recipe, strings.TrimPrefix(nextLine.text, "\t"))
+
+		p.idx = next
+	}
+
+	return rule
+}
+
+func isInclude(line string) bool {
+	word := firstWord(line)
+
+	for _, k := range includeKeywords {
+		if word == k {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isAssignmentOp(s string) bool {
+	return strings.HasPrefix(s, ":=") || strings.HasPrefix(s, "::=")
+}
+
+func firstWord(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[0]
+}
+
+// stripComment removes a trailing `# comment` from a non-recipe line
+func stripComment(line string) string {
+	if i := strings.Index(line, "#"); i >= 0 {
+		return line[:i]
+	}
+
+	return line
+}
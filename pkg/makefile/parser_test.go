@@ -0,0 +1,126 @@
+package makefile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseComments(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		directive string
+		value     string
+		warnings  int
+	}{
+		{name: "prose", line: "# builds the whole repo"},
+		{name: "prose starting with a directive name", line: "# task runner for the whole repo"},
+		{name: "prose starting with after", line: "# after this, run it"},
+		{name: "commented out include", line: "# include ./a/a.mk"},
+		{name: "unprefixed task", line: "# task", warnings: 1},
+		{name: "unprefixed go", line: "# go ./services/api", warnings: 1},
+		{name: "unprefixed timeout", line: "# timeout build 5m", warnings: 1},
+		{name: "unprefixed hermetic", line: "# hermetic", warnings: 1},
+		{name: "unprefixed passenv", line: "# passenv GOPATH AWS_PROFILE", warnings: 1},
+		{name: "unprefixed after", line: "# after migrate seed", warnings: 1},
+		{name: "unprefixed on-exit", line: "# on-exit restart", warnings: 1},
+		{name: "unprefixed makeargs", line: "# makeargs -j4 VERBOSE=1", warnings: 1},
+		{name: "unprefixed driver", line: "# driver npm", warnings: 1},
+		{name: "prose starting with timeout", line: "# timeout handling is in the api"},
+		{name: "prose starting with go", line: "# go here for the docs"},
+		{name: "prose starting with passenv", line: "# passenv is set below"},
+		{name: "double hash", line: "## task"},
+		{name: "prefixed", line: "#makeup: task", directive: taskDirective},
+		{name: "prefixed with value", line: "#makeup: after migrate seed", directive: afterDirective, value: "migrate seed"},
+		{name: "prefixed without space", line: "#makeup:go ./api", directive: goDirective, value: "./api"},
+		{name: "prefixed unknown", line: "#makeup: bogus x", warnings: 1},
+		{name: "legacy check", line: "# check go version", directive: checkDirective, value: "go version"},
+		{name: "legacy extern", line: "# extern ../other", directive: externDirective, value: "../other"},
+		{name: "legacy prefixed", line: "#makeup: equal 1.21", directive: equalDirective, value: "1.21"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseAST("main.mk", strings.NewReader(tt.line+"\n"))
+			if err != nil {
+				t.Fatalf("parseAST: %s", err)
+			}
+
+			if len(f.Warnings) != tt.warnings {
+				t.Errorf("got %d warnings, want %d: %v", len(f.Warnings), tt.warnings, f.Warnings)
+			}
+
+			d, ok := f.Nodes[0].(*Directive)
+
+			if tt.directive == "" {
+				if ok {
+					t.Fatalf("got directive %s, want a comment", d.Name)
+				}
+
+				return
+			}
+
+			if !ok {
+				t.Fatalf("got %T, want a directive", f.Nodes[0])
+			}
+
+			if d.Name != tt.directive || d.Value != tt.value {
+				t.Errorf("got %s %q, want %s %q", d.Name, d.Value, tt.directive, tt.value)
+			}
+		})
+	}
+}
+
+func TestParseBytesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{name: "empty", src: ""},
+		{name: "checks and includes", src: "# check go version\n# equal 1.21\n\ninclude ./a/a.mk\ninclude ./b/b.mk\n"},
+		{name: "continuations", src: "include ./a/a.mk \\\n\t./b/b.mk\n"},
+		{name: "directives and comments", src: "## notes\n# task runner\n#makeup: task\ninclude ./a/a.mk\n"},
+		{name: "rules and assignments", src: "VAR := 1\n\n# override\na/build:\n\t@echo $(VAR)\n"},
+		{name: "define", src: "define BODY\nline one\nline two\nendef\n"},
+		{name: "crlf", src: "include ./a/a.mk\r\n# check x\r\n# equal y\r\n"},
+		{name: "no final newline", src: "include ./a/a.mk"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseAST("main.mk", strings.NewReader(tt.src))
+			if err != nil {
+				t.Fatalf("parseAST: %s", err)
+			}
+
+			if got := string(f.Bytes()); got != tt.src {
+				t.Errorf("got %q, want %q", got, tt.src)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line int
+	}{
+		{name: "recipe before target", src: "\n\t@echo hi\n", line: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseAST("main.mk", strings.NewReader(tt.src))
+
+			parseErr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("got %v, want a ParseError", err)
+			}
+
+			if parseErr.Pos.Line != tt.line {
+				t.Errorf("got line %d, want %d", parseErr.Pos.Line, tt.line)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package makefile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/pkg/errors"
+)
+
+// readyTarget is the optional target that succeeds once a running component can serve requests
+const readyTarget = "ready"
+
+// readyInterval is how long makeup waits between runs of a component's ready target
+const readyInterval = time.Second
+
+// defaultReadyTimeout is how long a component has to become ready if it has no `#makeup: timeout ready` line
+const defaultReadyTimeout = time.Minute
+
+// waitReady runs the ready target of each running component that has one until it succeeds, failing if
+// a component isn't ready within its ready timeout
+func (m *Makefile) waitReady(ctx context.Context) error {
+	errGroup, ctx := errgroup.WithContext(ctx)
+
+	for _, c := range m.Components {
+		component := c
+
+		// tasks have already finished, so there's nothing to wait for
+		if component.Task {
+			continue
+		}
+
+		has, err := m.hasTarget(ctx, component, readyTarget)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read targets of %s", component.Name)
+		}
+
+		if !has {
+			continue
+		}
+
+		env, err := m.runEnv(ctx, component, readyTarget)
+		if err != nil {
+			return errors.Wrapf(err, "failed to runEnv %s", component.Name)
+		}
+
+		errGroup.Go(func() error {
+			return m.pollReady(ctx, component, env)
+		})
+	}
+
+	return errGroup.Wait()
+}
+
+// pollReady runs the component's ready target every readyInterval until it succeeds or times out
+func (m *Makefile) pollReady(ctx context.Context, c *Component, env []string) error {
+	timeout := m.timeoutFor(c, readyTarget)
+	if timeout == 0 {
+		timeout = defaultReadyTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	fmt.Println("waiting for:", c.Name)
+
+	for {
+		_, err := m.runTarget(ctx, c, readyTarget, io.Discard, env)
+		if err == nil {
+			fmt.Println("ready:", c.Name)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "stopped waiting")
+		}
+
+		if time.Now().Add(readyInterval).After(deadline) {
+			return errors.Wrapf(err, "%s was not ready after %s", c.Name, timeout)
+		}
+
+		select {
+		case <-time.After(readyInterval):
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "stopped waiting")
+		}
+	}
+}
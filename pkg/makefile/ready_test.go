@@ -0,0 +1,44 @@
+package makefile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollReady(t *testing.T) {
+	dir := writeMakefile(t, "ready:\n\t@test -f up\n")
+
+	c := &Component{Name: "svc", Dir: dir, File: filepath.Join(dir, "Makefile"), Driver: drivers[makeDriverName]}
+	m := &Makefile{Components: []*Component{c}, Make: MakeConfig{Native: true}}
+
+	go func() {
+		time.Sleep(readyInterval + readyInterval/2)
+		_ = os.WriteFile(filepath.Join(dir, "up"), nil, 0644)
+	}()
+
+	if err := m.pollReady(context.Background(), c, nil); err != nil {
+		t.Fatalf("pollReady: %s", err)
+	}
+}
+
+func TestPollReadyTimeout(t *testing.T) {
+	dir := writeMakefile(t, "ready:\n\t@test -f up\n")
+
+	c := &Component{
+		Name:     "svc",
+		Dir:      dir,
+		File:     filepath.Join(dir, "Makefile"),
+		Driver:   drivers[makeDriverName],
+		Timeouts: map[string]time.Duration{readyTarget: 2 * readyInterval},
+	}
+	m := &Makefile{Components: []*Component{c}, Make: MakeConfig{Native: true}}
+
+	err := m.pollReady(context.Background(), c, nil)
+	if err == nil || !strings.Contains(err.Error(), "was not ready") {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,458 @@
+package makefile
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	TestPassed  = "pass"
+	TestFailed  = "fail"
+	TestSkipped = "skip"
+)
+
+// TestResult is the outcome of a component's test target
+type TestResult struct {
+	Component string
+	// Status is TestPassed, TestFailed, or TestSkipped if the component wasn't tested
+	Status   string
+	Duration time.Duration
+	Output   string
+	// Cases are the individual tests, for components whose test targets output `go test -json`
+	Cases []*TestCase
+	// Cached is set if the result is from an earlier run, and nothing it depends on has changed since
+	Cached bool
+	// Attempts is how many times the test target ran, and Flaky is set if it only passed on a retry
+	Attempts int
+	Flaky    bool
+}
+
+// TestCase is the outcome of a single Go test
+type TestCase struct {
+	Package  string
+	Name     string
+	Status   string
+	Duration time.Duration
+	Output   string
+}
+
+// counts returns how many of the result's test cases passed, failed and were skipped
+func (r *TestResult) counts() (int, int, int) {
+	passed, failed, skipped := 0, 0, 0
+
+	for _, c := range r.Cases {
+		switch c.Status {
+		case TestPassed:
+			passed++
+		case TestFailed:
+			failed++
+		case TestSkipped:
+			skipped++
+		}
+	}
+
+	return passed, failed, skipped
+}
+
+// goTestEvent is a line of `go test -json` output
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// goTestWriter turns `go test -json` output back into text as it's written, collecting the result of
+// each test along the way. Lines that aren't test events (such as build errors) are passed through.
+type goTestWriter struct {
+	lock    sync.Mutex
+	out     io.Writer
+	partial []byte
+	text    strings.Builder
+	cases   []*TestCase
+	byName  map[string]*TestCase
+}
+
+func newGoTestWriter(out io.Writer) *goTestWriter {
+	g := &goTestWriter{
+		out:    out,
+		cases:  []*TestCase{},
+		byName: map[string]*TestCase{},
+	}
+
+	return g
+}
+
+func (g *goTestWriter) Write(p []byte) (int, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.partial = append(g.partial, p...)
+
+	for {
+		newline := bytes.IndexByte(g.partial, '\n')
+		if newline < 0 {
+			break
+		}
+
+		line := string(g.partial[:newline+1])
+		g.partial = g.partial[newline+1:]
+
+		if err := g.line(line); err != nil {
+			return len(p), err
+		}
+	}
+
+	return len(p), nil
+}
+
+// flush handles the last line of output if it didn't end with a newline
+func (g *goTestWriter) flush() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if len(g.partial) == 0 {
+		return nil
+	}
+
+	line := string(g.partial)
+	g.partial = nil
+
+	return g.line(line)
+}
+
+func (g *goTestWriter) line(line string) error {
+	event := goTestEvent{}
+
+	if err := json.Unmarshal([]byte(line), &event); err != nil || event.Action == "" {
+		return g.write(line)
+	}
+
+	var testCase *TestCase
+
+	if event.Test != "" {
+		key := event.Package + "/" + event.Test
+
+		testCase = g.byName[key]
+		if testCase == nil {
+			testCase = &TestCase{Package: event.Package, Name: event.Test}
+			g.byName[key] = testCase
+			g.cases = append(g.cases, testCase)
+		}
+	}
+
+	switch event.Action {
+	case "output":
+		if testCase != nil {
+			testCase.Output += event.Output
+		}
+
+		return g.write(event.Output)
+	case TestPassed, TestFailed, TestSkipped:
+		if testCase != nil {
+			testCase.Status = event.Action
+			testCase.Duration = time.Duration(event.Elapsed * float64(time.Second))
+		}
+	}
+
+	return nil
+}
+
+// testCases returns the result of each test. Tests that started but never finished, such as when one
+// panics or the package fails after they ran, have failed.
+func (g *goTestWriter) testCases() []*TestCase {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for _, c := range g.cases {
+		if c.Status == "" {
+			c.Status = TestFailed
+		}
+	}
+
+	return g.cases
+}
+
+func (g *goTestWriter) write(text string) error {
+	g.text.WriteString(text)
+
+	if _, err := io.WriteString(g.out, text); err != nil {
+		return errors.Wrap(err, "failed to WriteString")
+	}
+
+	return nil
+}
+
+// printTestTable prints a table of each component's test result
+func printTestTable(results []*TestResult) {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "COMPONENT\tRESULT\tDURATION\tTESTS")
+
+	for _, r := range results {
+		duration, tests := "", ""
+
+		if r.Status != TestSkipped {
+			duration = r.Duration.Round(time.Millisecond).String()
+		}
+
+		if len(r.Cases) > 0 {
+			passed, failed, skipped := r.counts()
+			tests = fmt.Sprintf("%d passed, %d failed, %d skipped", passed, failed, skipped)
+		}
+
+		status := r.Status
+		if r.Cached {
+			status += " (cached)"
+		}
+
+		if r.Flaky {
+			status += " (flaky)"
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", r.Component, status, duration, tests)
+	}
+
+	table.Flush()
+}
+
+// printFlaky lists the components whose tests only passed on a retry
+func printFlaky(results []*TestResult) {
+	flaky := []*TestResult{}
+	for _, r := range results {
+		if r.Flaky {
+			flaky = append(flaky, r)
+		}
+	}
+
+	if len(flaky) == 0 {
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("flaky:")
+
+	for _, r := range flaky {
+		fmt.Printf("  %s: passed on attempt %d\n", r.Component, r.Attempts)
+	}
+}
+
+// jsonReport is the JSON report of a test run, which can also be read back for its durations
+type jsonReport struct {
+	Results []jsonResult `json:"results"`
+	// Flaky are the components whose tests only passed on a retry
+	Flaky []string `json:"flaky"`
+}
+
+type jsonResult struct {
+	Component string `json:"component"`
+	Status    string `json:"status"`
+	// Duration is in seconds
+	Duration float64    `json:"duration"`
+	Attempts int        `json:"attempts"`
+	Flaky    bool       `json:"flaky"`
+	Cached   bool       `json:"cached"`
+	Output   string     `json:"output,omitempty"`
+	Cases    []jsonCase `json:"cases,omitempty"`
+}
+
+type jsonCase struct {
+	Package  string  `json:"package"`
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration"`
+}
+
+// WriteJSON writes the results to path as a JSON report, including the output of failed components
+func WriteJSON(path string, results []*TestResult) error {
+	report := jsonReport{Results: []jsonResult{}, Flaky: []string{}}
+
+	for _, r := range results {
+		result := jsonResult{
+			Component: r.Component,
+			Status:    r.Status,
+			Duration:  r.Duration.Seconds(),
+			Attempts:  r.Attempts,
+			Flaky:     r.Flaky,
+			Cached:    r.Cached,
+		}
+
+		if r.Status == TestFailed {
+			result.Output = r.Output
+		}
+
+		for _, c := range r.Cases {
+			result.Cases = append(result.Cases, jsonCase{
+				Package:  c.Package,
+				Name:     c.Name,
+				Status:   c.Status,
+				Duration: c.Duration.Seconds(),
+			})
+		}
+
+		if r.Flaky {
+			report.Flaky = append(report.Flaky, r.Component)
+		}
+
+		report.Results = append(report.Results, result)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to MarshalIndent")
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return errors.Wrapf(err, "failed to os.WriteFile %s", path)
+	}
+
+	return nil
+}
+
+// ReadDurations returns how long each component's tests took from a JSON report, leaving out those that
+// were skipped or cached
+func ReadDurations(path string) (map[string]time.Duration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to ReadFile %s", path)
+	}
+
+	report := jsonReport{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, errors.Wrapf(err, "failed to Unmarshal %s", path)
+	}
+
+	durations := map[string]time.Duration{}
+
+	for _, r := range report.Results {
+		if r.Status != TestSkipped && !r.Cached {
+			durations[r.Component] = time.Duration(r.Duration * float64(time.Second))
+		}
+	}
+
+	return durations, nil
+}
+
+// junitSuites is the root element of a JUnit XML report
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut *junitOutput  `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",cdata"`
+}
+
+type junitOutput struct {
+	Text string `xml:",cdata"`
+}
+
+// WriteJUnit writes the results to path as a JUnit XML report, with a test suite for each component.
+// Components that output `go test -json` have a test case for each of their tests, and others have a
+// single test case for their test target, as do components that failed without any of their tests failing.
+func WriteJUnit(path string, results []*TestResult) error {
+	report := junitSuites{Suites: []junitSuite{}}
+
+	for _, r := range results {
+		suite := junitSuite{
+			Name: r.Component,
+			Time: junitTime(r.Duration),
+		}
+
+		casesFailed := false
+
+		for _, c := range r.Cases {
+			suite.Cases = append(suite.Cases, newJUnitCase(c.Package, c.Name, c.Status, c.Duration, c.Output))
+			casesFailed = casesFailed || c.Status != TestPassed && c.Status != TestSkipped
+		}
+
+		// a component that failed without a failing test, such as when its package didn't build, still
+		// needs a failure in the report
+		if len(r.Cases) == 0 || (r.Status == TestFailed && !casesFailed) {
+			suite.Cases = append(suite.Cases, newJUnitCase(r.Component, "test", r.Status, r.Duration, r.Output))
+		}
+
+		for _, c := range suite.Cases {
+			suite.Tests++
+
+			if c.Failure != nil {
+				suite.Failures++
+			}
+
+			if c.Skipped != nil {
+				suite.Skipped++
+			}
+		}
+
+		report.Suites = append(report.Suites, suite)
+	}
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to MarshalIndent")
+	}
+
+	data = append([]byte(xml.Header), append(data, '\n')...)
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrapf(err, "failed to os.WriteFile %s", path)
+	}
+
+	return nil
+}
+
+func newJUnitCase(classname, name, status string, duration time.Duration, output string) junitCase {
+	c := junitCase{
+		Name:      name,
+		Classname: classname,
+		Time:      junitTime(duration),
+	}
+
+	// anything that didn't pass or skip has failed, so that a test without a result isn't reported as passing
+	switch status {
+	case TestPassed:
+		if output != "" {
+			c.SystemOut = &junitOutput{Text: output}
+		}
+	case TestSkipped:
+		c.Skipped = &junitMessage{Message: "skipped", Text: output}
+	default:
+		c.Failure = &junitMessage{Message: "failed", Text: output}
+	}
+
+	return c
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
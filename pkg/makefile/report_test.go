@@ -0,0 +1,228 @@
+package makefile
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// goTestEvents renders `go test -json` events, one per line
+func goTestEvents(t *testing.T, events ...goTestEvent) string {
+	t.Helper()
+
+	out := bytes.Buffer{}
+
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatalf("Marshal: %s", err)
+		}
+
+		out.Write(append(data, '\n'))
+	}
+
+	return out.String()
+}
+
+func TestGoTestWriter(t *testing.T) {
+	input := goTestEvents(t,
+		goTestEvent{Action: "run", Package: "ex.com/a", Test: "TestPass"},
+		goTestEvent{Action: "output", Package: "ex.com/a", Test: "TestPass", Output: "=== RUN   TestPass\n"},
+		goTestEvent{Action: "pass", Package: "ex.com/a", Test: "TestPass", Elapsed: 0.5},
+		goTestEvent{Action: "run", Package: "ex.com/a", Test: "TestSkip"},
+		goTestEvent{Action: "skip", Package: "ex.com/a", Test: "TestSkip"},
+		goTestEvent{Action: "run", Package: "ex.com/a", Test: "TestPanic"},
+		goTestEvent{Action: "output", Package: "ex.com/a", Test: "TestPanic", Output: "panic: boom\n"},
+	)
+
+	// build errors aren't JSON, and the last package event has no newline
+	input = "# ex.com/b\nb.go:1: undefined: x\n" + input + `{"Action":"fail","Package":"ex.com/a","Elapsed":1}`
+
+	terminal := &bytes.Buffer{}
+	writer := newGoTestWriter(terminal)
+
+	// the output arrives in chunks that split lines
+	for i := 0; i < len(input); i += 7 {
+		end := i + 7
+		if end > len(input) {
+			end = len(input)
+		}
+
+		if _, err := writer.Write([]byte(input[i:end])); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+
+	if err := writer.flush(); err != nil {
+		t.Fatalf("flush: %s", err)
+	}
+
+	wantText := "# ex.com/b\nb.go:1: undefined: x\n=== RUN   TestPass\npanic: boom\n"
+
+	if terminal.String() != wantText || writer.text.String() != wantText {
+		t.Errorf("got %q, want %q", terminal.String(), wantText)
+	}
+
+	cases := writer.testCases()
+
+	want := []struct {
+		name, status string
+	}{
+		{"TestPass", TestPassed},
+		{"TestSkip", TestSkipped},
+		{"TestPanic", TestFailed},
+	}
+
+	if len(cases) != len(want) {
+		t.Fatalf("got %d cases, want %d", len(cases), len(want))
+	}
+
+	for i, w := range want {
+		if cases[i].Name != w.name || cases[i].Status != w.status {
+			t.Errorf("got %s %s, want %s %s", cases[i].Name, cases[i].Status, w.name, w.status)
+		}
+	}
+
+	if cases[0].Duration != 500*time.Millisecond {
+		t.Errorf("got duration %s, want 500ms", cases[0].Duration)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   *TestResult
+		tests    int
+		failures int
+		skipped  int
+	}{
+		{
+			name:   "passing target",
+			result: &TestResult{Component: "a", Status: TestPassed},
+			tests:  1,
+		},
+		{
+			name:     "failing target",
+			result:   &TestResult{Component: "a", Status: TestFailed, Output: "boom"},
+			tests:    1,
+			failures: 1,
+		},
+		{
+			name:    "skipped component",
+			result:  &TestResult{Component: "a", Status: TestSkipped},
+			tests:   1,
+			skipped: 1,
+		},
+		{
+			name: "failing test",
+			result: &TestResult{Component: "a", Status: TestFailed, Cases: []*TestCase{
+				{Package: "ex.com/a", Name: "TestOne", Status: TestPassed},
+				{Package: "ex.com/a", Name: "TestTwo", Status: TestFailed},
+			}},
+			tests:    2,
+			failures: 1,
+		},
+		{
+			name: "failed without a failing test",
+			result: &TestResult{Component: "a", Status: TestFailed, Output: "build failed", Cases: []*TestCase{
+				{Package: "ex.com/a", Name: "TestOne", Status: TestPassed},
+				{Package: "ex.com/a", Name: "TestTwo", Status: TestSkipped},
+			}},
+			tests:    3,
+			failures: 1,
+			skipped:  1,
+		},
+		{
+			name: "test without a result",
+			result: &TestResult{Component: "a", Status: TestPassed, Cases: []*TestCase{
+				{Package: "ex.com/a", Name: "TestOne"},
+			}},
+			tests:    1,
+			failures: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "junit.xml")
+
+			if err := WriteJUnit(path, []*TestResult{tt.result}); err != nil {
+				t.Fatalf("WriteJUnit: %s", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile: %s", err)
+			}
+
+			report := junitSuites{}
+			if err := xml.Unmarshal(data, &report); err != nil {
+				t.Fatalf("Unmarshal: %s", err)
+			}
+
+			if len(report.Suites) != 1 {
+				t.Fatalf("got %d suites, want 1", len(report.Suites))
+			}
+
+			suite := report.Suites[0]
+
+			if suite.Tests != tt.tests || suite.Failures != tt.failures || suite.Skipped != tt.skipped {
+				t.Errorf("got %d tests, %d failures and %d skipped, want %d, %d and %d", suite.Tests, suite.Failures, suite.Skipped, tt.tests, tt.failures, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+
+	results := []*TestResult{
+		{Component: "a", Status: TestPassed, Duration: 2 * time.Second, Output: "ok", Attempts: 2, Flaky: true},
+		{Component: "b", Status: TestFailed, Duration: time.Second, Output: "boom", Attempts: 1, Cases: []*TestCase{
+			{Package: "ex.com/b", Name: "TestB", Status: TestFailed, Duration: time.Second},
+		}},
+		{Component: "c", Status: TestPassed, Duration: time.Second, Cached: true},
+		{Component: "d", Status: TestSkipped},
+	}
+
+	if err := WriteJSON(path, results); err != nil {
+		t.Fatalf("WriteJSON: %s", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+
+	report := jsonReport{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+
+	if len(report.Flaky) != 1 || report.Flaky[0] != "a" {
+		t.Errorf("got flaky %v, want [a]", report.Flaky)
+	}
+
+	// only failed components include their output
+	if report.Results[0].Output != "" || report.Results[1].Output != "boom" {
+		t.Errorf("got outputs %q and %q, want \"\" and \"boom\"", report.Results[0].Output, report.Results[1].Output)
+	}
+
+	if len(report.Results[1].Cases) != 1 || report.Results[1].Cases[0].Status != TestFailed {
+		t.Errorf("got cases %v, want TestB failed", report.Results[1].Cases)
+	}
+
+	// cached and skipped components didn't take their durations in this run
+	durations, err := ReadDurations(path)
+	if err != nil {
+		t.Fatalf("ReadDurations: %s", err)
+	}
+
+	if len(durations) != 2 || durations["a"] != 2*time.Second || durations["b"] != time.Second {
+		t.Errorf("got durations %v, want a: 2s and b: 1s", durations)
+	}
+}
@@ -3,9 +3,11 @@ package makefile
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
@@ -13,73 +15,241 @@ import (
 	"github.com/pkg/errors"
 )
 
-// RunAll runs all of the project components
-func (m *Makefile) RunAll() error {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return errors.Wrap(err, "failed to Getwd")
-	}
+// envLineRegex matches KEY=VALUE lines, and not lines such as a task runner echoing `echo "KEY=VALUE"`
+var envLineRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+const (
+	onExitStopAll = "stop-all"
+	onExitIgnore  = "ignore"
+	onExitRestart = "restart"
+)
+
+// onExitPolicies are what can happen when a component's run target exits, chosen with `#makeup: on-exit`
+var onExitPolicies = []string{onExitStopAll, onExitIgnore, onExitRestart}
+
+// restartDelay is how long makeup waits before restarting a component whose run target exited
+const restartDelay = time.Second
+
+// RunAll runs all of the project components. Tasks run until they exit, and components that run
+// after them wait for them to succeed, with everything being stopped if one fails. What happens when
+// other components exit depends on their on-exit policy. The pre-up hook runs first, post-up once every
+// task has succeeded and every component with a ready target is ready, and pre-down before components
+// are stopped.
+func (m *Makefile) RunAll(ctx context.Context) error {
+	return m.runAll(ctx, nil)
+}
+
+// runAll runs the project like RunAll, and if up is given, calls it after the post-up hook and then
+// stops the project once it returns. Before either, it waits for components with a ready target to be ready.
+func (m *Makefile) runAll(ctx context.Context, up func(ctx context.Context) error) error {
+	interrupted := ctx
 
-	binBase := filepath.Join(cwd, ".bin")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	errGroup, _ := errgroup.WithContext(context.Background())
+	// components are stopped once the pre-down hook has run, rather than as soon as ctx is done
+	runCtx, stopAll := context.WithCancel(context.Background())
+	defer stopAll()
 
-	for _, incl := range m.Includes {
-		componentDir := filepath.Dir(incl.Path)
-		componentMakefile := filepath.Base(incl.Path)
-		componentName := strings.TrimSuffix(componentMakefile, ".mk")
+	go func() {
+		select {
+		case <-ctx.Done():
+			if runCtx.Err() != nil {
+				return
+			}
 
-		fmt.Println("running:", componentName)
+			if err := m.runHook(runCtx, "pre-down"); err != nil {
+				fmt.Println("stopping after error:", err.Error())
+			}
 
-		binDest := filepath.Join(binBase, componentName)
+			stopAll()
+		case <-runCtx.Done():
+		}
+	}()
+
+	if err := m.runHook(ctx, "pre-up"); err != nil {
+		return err
+	}
 
-		componentEnv, err := m.envForMkPath(incl.Path)
+	errGroup, _ := errgroup.WithContext(ctx)
+
+	tasks := newTaskRuns(m.Components)
+	failed := &failures{}
+
+	// every env is ready before anything starts, so that a failure here doesn't leave components running
+	envs := map[*Component][]string{}
+
+	for _, c := range m.Components {
+		env, err := m.runEnv(ctx, c, "run")
 		if err != nil {
-			return errors.Wrapf(err, "failed to envForMkPath %s", incl.Path)
+			return errors.Wrapf(err, "failed to runEnv %s", c.Name)
 		}
 
-		// grab the 'env' target output and add some makeup-specific things
-		env := append(
-			strings.Split(componentEnv, "\n"),
-			[]string{
-				fmt.Sprintf("BIN_DEST=%s", binDest),
-			}...,
-		)
+		envs[c] = env
+	}
+
+	for _, c := range m.Components {
+		component, env := c, envs[c]
+
+		fmt.Println("running:", component.Name)
 
 		errGroup.Go(func() error {
-			writer := exec.NewPrefixWriter(componentName, os.Stdout)
+			// the components after a failed task are not started, which the task summary shows
+			if err := tasks.wait(ctx, component.After); err != nil {
+				if component.Task {
+					tasks.finish(component, false, err, 0)
+				}
 
-			if _, err := exec.RunInDir(fmt.Sprintf("make -s -f %s run", componentMakefile), componentDir, writer, env...); err != nil {
-				return errors.Wrapf(err, "failed to run %s", componentDir)
+				return nil
 			}
 
-			return nil
+			writer := exec.NewPrefixWriter(component.Name, os.Stdout)
+
+			for {
+				start := time.Now()
+
+				_, err := m.runTarget(runCtx, component, "run", writer, env)
+				if err != nil {
+					err = errors.Wrapf(err, "failed to run %s", component.Dir)
+				}
+
+				// components stopped by makeup haven't failed
+				stopped := runCtx.Err() != nil
+				if stopped {
+					err = nil
+				}
+
+				if component.Task {
+					tasks.finish(component, true, err, time.Since(start))
+
+					// the components running after the task can't start, so nothing else is left running
+					if err != nil {
+						failed.add(component.Name, err)
+						cancel()
+					}
+
+					return nil
+				}
+
+				if stopped {
+					return nil
+				}
+
+				policy := m.onExit(component)
+
+				if err != nil && policy != onExitRestart {
+					failed.add(component.Name, err)
+				}
+
+				switch policy {
+				case onExitRestart:
+					fmt.Println("restarting:", component.Name)
+
+					select {
+					case <-time.After(restartDelay):
+						continue
+					case <-ctx.Done():
+					}
+				case onExitStopAll:
+					fmt.Println("stopping the project:", component.Name, "exited")
+					cancel()
+				}
+
+				return nil
+			}
 		})
 	}
 
-	return errGroup.Wait()
+	errGroup.Go(func() error {
+		// a failed task has already reported its error
+		if err := tasks.wait(ctx, tasks.names()); err != nil {
+			return nil
+		}
+
+		// readiness only matters to the post-up hook and the caller, so it isn't checked otherwise
+		if containsString(m.Hooks, "post-up") || up != nil {
+			if err := m.waitReady(ctx); err != nil {
+				cancel()
+				return errors.Wrap(err, "failed to waitReady")
+			}
+		}
+
+		if err := m.runHook(runCtx, "post-up"); err != nil {
+			cancel()
+			return err
+		}
+
+		if up == nil {
+			return nil
+		}
+
+		defer cancel()
+
+		return up(ctx)
+	})
+
+	hookErr := errGroup.Wait()
+
+	if err := failed.summarize("run"); err != nil {
+		return err
+	}
+
+	if hookErr != nil {
+		return hookErr
+	}
+
+	if interrupted.Err() != nil {
+		return errors.Wrap(interrupted.Err(), "makeup was stopped")
+	}
+
+	return nil
 }
 
-func (m *Makefile) envForMkPath(mkPath string) (string, error) {
-	componentDir := filepath.Dir(mkPath)
-	componentMakefile := filepath.Base(mkPath)
-	componentName := strings.TrimSuffix(componentMakefile, ".mk")
+// onExit returns what should happen when the component's run target exits, which is chosen by its
+// `#makeup: on-exit` line or else the global one. Otherwise the project is stopped if FailFast is set, and
+// the other components carry on if not.
+func (m *Makefile) onExit(c *Component) string {
+	switch {
+	case c.OnExit != "":
+		return c.OnExit
+	case m.OnExit != "":
+		return m.OnExit
+	case m.FailFast:
+		return onExitStopAll
+	}
 
-	var out string
-	var err error
+	return onExitIgnore
+}
 
-	if m.ContainsOverride(componentName, "env") {
-		overrideTarget := fmt.Sprintf("%s/%s", componentName, "env")
+// runEnv returns the variables that the component's run target (or another that runs alongside it,
+// for the given command) gets on top of the ones it inherits
+func (m *Makefile) runEnv(ctx context.Context, c *Component, command string) ([]string, error) {
+	targetEnv, err := m.targetEnv(c, command)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to targetEnv")
+	}
 
-		out, err = exec.RunSilent(fmt.Sprintf("make -s -f %s %s", m.FullPath, overrideTarget), "")
-		if err != nil {
-			return "", errors.Wrapf(err, "failed to get override env %s", componentDir)
-		}
-	} else {
-		out, err = exec.RunSilent(fmt.Sprintf("make -s -f %s env", componentMakefile), componentDir)
-		if err != nil {
-			return "", errors.Wrapf(err, "failed to get env %s", componentDir)
-		}
+	componentEnv, err := m.envFor(ctx, c, targetEnv)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to envFor %s", c.Name)
+	}
+
+	env := []string{}
+	if componentEnv != "" {
+		env = strings.Split(componentEnv, "\n")
+	}
+
+	// grab the 'env' target output and add the makeup-specific things
+	env = append(env, targetEnv...)
+
+	return env, nil
+}
+
+// envFor runs the component's env target with the given env, returning the KEY=VALUE lines it outputs
+func (m *Makefile) envFor(ctx context.Context, c *Component, env []string) (string, error) {
+	out, err := m.runTarget(ctx, c, "env", io.Discard, env)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get env %s", c.Dir)
 	}
 
 	envLines := []string{}
@@ -87,7 +257,7 @@ func (m *Makefile) envForMkPath(mkPath string) (string, error) {
 	// remove any lines that don't look like an env statement, i.e. KEY=VALUE
 	outLines := strings.Split(out, "\n")
 	for _, l := range outLines {
-		if strings.Contains(l, "=") {
+		if envLineRegex.MatchString(l) {
 			envLines = append(envLines, l)
 		}
 	}
@@ -0,0 +1,109 @@
+package makefile
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// targets are the lifecycle targets that do nothing, for components in test projects
+const targets = "build:\n\t@true\ntest:\n\t@true\nenv:\n\t@true\nclean:\n\t@true\n"
+
+// writeProject writes the files to a new directory and parses its main.mk from there, as makeup does,
+// running targets without make
+func writeProject(t *testing.T, files map[string]string) *Makefile {
+	t.Helper()
+
+	root := writeFiles(t, files)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %s", err)
+	}
+
+	if err := os.Chdir(root); err != nil {
+		t.Fatalf("Chdir: %s", err)
+	}
+
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	mk, err := Parse(filepath.Join(root, "main.mk"))
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+
+	mk.Make = MakeConfig{Native: true}
+
+	return mk
+}
+
+func TestRunAllEnvFailure(t *testing.T) {
+	mk := writeProject(t, map[string]string{
+		"main.mk": "include ./a/a.mk ./b/b.mk\n",
+		"a/a.mk":  targets + "run:\n\t@touch ran\n",
+		"b/b.mk":  "build:\n\t@true\ntest:\n\t@true\nenv:\n\t@false\nclean:\n\t@true\nrun:\n\t@true\n",
+	})
+
+	if err := mk.RunAll(context.Background()); err == nil {
+		t.Fatalf("got no error from a failing env target")
+	}
+
+	if _, err := os.Stat(filepath.Join(mk.Root(), "a", "ran")); err == nil {
+		t.Errorf("a was started even though b's env failed")
+	}
+}
+
+func TestRunAllOnExitStopAll(t *testing.T) {
+	mk := writeProject(t, map[string]string{
+		"main.mk": "#makeup: on-exit stop-all\ninclude ./a/a.mk\ninclude ./b/b.mk\n",
+		"a/a.mk":  targets + "run:\n\t@sleep 1; exit 3\n",
+		"b/b.mk":  targets + "run:\n\t@sleep 30\n",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	start := time.Now()
+
+	err := mk.RunAll(ctx)
+
+	var componentsErr *ComponentsError
+	if !errors.As(err, &componentsErr) || strings.Join(componentsErr.Components, " ") != "a" {
+		t.Fatalf("got %v, want a to have failed", err)
+	}
+
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("b kept running after a exited")
+	}
+}
+
+func TestRunAllOnExitRestart(t *testing.T) {
+	mk := writeProject(t, map[string]string{
+		"main.mk": "#makeup: on-exit restart\ninclude ./a/a.mk\n",
+		"a/a.mk":  targets + "run:\n\t@echo ran >> runs.log; exit 3\n",
+	})
+
+	// a is restarted until makeup is stopped
+	ctx, cancel := context.WithTimeout(context.Background(), restartDelay*2+restartDelay/2)
+	defer cancel()
+
+	err := mk.RunAll(ctx)
+
+	var componentsErr *ComponentsError
+	if errors.As(err, &componentsErr) {
+		t.Errorf("got %v, want restarted components not to have failed", err)
+	}
+
+	log, readErr := os.ReadFile(filepath.Join(mk.Root(), "a", "runs.log"))
+	if readErr != nil {
+		t.Fatalf("ReadFile: %s", readErr)
+	}
+
+	if runs := strings.Count(string(log), "ran"); runs < 2 {
+		t.Errorf("a ran %d times, want it restarted", runs)
+	}
+}
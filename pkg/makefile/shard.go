@@ -0,0 +1,115 @@
+package makefile
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// Shard narrows the project down to the components in shard index (from 1) of count, so that CI machines
+// can each test part of the project. Every machine gets the same partition for the same components. If
+// durations are given (from an earlier run's JSON report), components are balanced so that the shards take
+// about as long as each other, and otherwise they are split by a hash of their names.
+func (m *Makefile) Shard(index, count int, durations map[string]time.Duration) error {
+	if count < 1 {
+		return fmt.Errorf("invalid shard %d/%d, the number of shards must be at least 1", index, count)
+	}
+
+	if index < 1 || index > count {
+		return fmt.Errorf("invalid shard %d/%d, the shard must be between 1 and %d", index, count, count)
+	}
+
+	var shards [][]*Component
+	if len(durations) > 0 {
+		shards = shardByDuration(m.Components, count, durations)
+	} else {
+		shards = shardByName(m.Components, count)
+	}
+
+	selected := map[*Component]bool{}
+	for _, c := range shards[index-1] {
+		selected[c] = true
+	}
+
+	// components keep their order from main.mk
+	components := []*Component{}
+	for _, c := range m.Components {
+		if selected[c] {
+			components = append(components, c)
+		}
+	}
+
+	m.Components = components
+
+	return nil
+}
+
+// shardByName assigns each component to a shard by a hash of its name
+func shardByName(components []*Component, count int) [][]*Component {
+	shards := make([][]*Component, count)
+
+	for _, c := range components {
+		hash := fnv.New32a()
+		hash.Write([]byte(c.Name))
+
+		i := int(hash.Sum32() % uint32(count))
+		shards[i] = append(shards[i], c)
+	}
+
+	return shards
+}
+
+// shardByDuration assigns the slowest components first, each to the shard with the least to do so far.
+// Components without a recorded duration are assumed to take the average.
+func shardByDuration(components []*Component, count int, durations map[string]time.Duration) [][]*Component {
+	var total time.Duration
+	known := 0
+
+	for _, c := range components {
+		if d, ok := durations[c.Name]; ok {
+			total += d
+			known++
+		}
+	}
+
+	average := time.Duration(0)
+	if known > 0 {
+		average = total / time.Duration(known)
+	}
+
+	durationOf := func(c *Component) time.Duration {
+		if d, ok := durations[c.Name]; ok {
+			return d
+		}
+
+		return average
+	}
+
+	sorted := append([]*Component{}, components...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		di, dj := durationOf(sorted[i]), durationOf(sorted[j])
+		if di != dj {
+			return di > dj
+		}
+
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	shards := make([][]*Component, count)
+	loads := make([]time.Duration, count)
+
+	for _, c := range sorted {
+		least := 0
+		for i := range loads {
+			if loads[i] < loads[least] {
+				least = i
+			}
+		}
+
+		shards[least] = append(shards[least], c)
+		loads[least] += durationOf(c)
+	}
+
+	return shards
+}
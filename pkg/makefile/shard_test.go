@@ -0,0 +1,105 @@
+package makefile
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func shardComponents(names ...string) []*Component {
+	components := []*Component{}
+
+	for _, name := range names {
+		components = append(components, &Component{Name: name})
+	}
+
+	return components
+}
+
+func componentNames(components []*Component) string {
+	names := []string{}
+
+	for _, c := range components {
+		names = append(names, c.Name)
+	}
+
+	return strings.Join(names, " ")
+}
+
+func TestShardByName(t *testing.T) {
+	names := []string{}
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("c%d", i))
+	}
+
+	seen := map[string]int{}
+
+	for index := 1; index <= 3; index++ {
+		m := &Makefile{Components: shardComponents(names...)}
+		if err := m.Shard(index, 3, nil); err != nil {
+			t.Fatalf("Shard: %s", err)
+		}
+
+		// the same components are in the shard each time, in main.mk's order
+		again := &Makefile{Components: shardComponents(names...)}
+		if err := again.Shard(index, 3, nil); err != nil {
+			t.Fatalf("Shard: %s", err)
+		}
+
+		if componentNames(m.Components) != componentNames(again.Components) {
+			t.Errorf("shard %d got %s, then %s", index, componentNames(m.Components), componentNames(again.Components))
+		}
+
+		for _, c := range m.Components {
+			seen[c.Name]++
+		}
+	}
+
+	for _, name := range names {
+		if seen[name] != 1 {
+			t.Errorf("%s is in %d shards, want 1", name, seen[name])
+		}
+	}
+}
+
+func TestShardByDuration(t *testing.T) {
+	durations := map[string]time.Duration{
+		"slow":   10 * time.Second,
+		"medium": 6 * time.Second,
+		"fast":   4 * time.Second,
+	}
+
+	// unknown is assumed to take the average, so the shards take 14s and 12.67s
+	want := []string{"fast slow", "medium unknown"}
+
+	for i, names := range want {
+		m := &Makefile{Components: shardComponents("fast", "medium", "slow", "unknown")}
+		if err := m.Shard(i+1, 2, durations); err != nil {
+			t.Fatalf("Shard: %s", err)
+		}
+
+		if got := componentNames(m.Components); got != names {
+			t.Errorf("shard %d got %q, want %q", i+1, got, names)
+		}
+	}
+}
+
+func TestShardInvalid(t *testing.T) {
+	tests := []struct {
+		index, count int
+		want         string
+	}{
+		{0, 2, "invalid shard 0/2, the shard must be between 1 and 2"},
+		{3, 2, "invalid shard 3/2, the shard must be between 1 and 2"},
+		{1, 0, "invalid shard 1/0, the number of shards must be at least 1"},
+	}
+
+	for _, tt := range tests {
+		m := &Makefile{Components: shardComponents("a")}
+
+		if err := m.Shard(tt.index, tt.count, nil); err == nil || err.Error() != tt.want {
+			t.Errorf("shard %d/%d got %v, want %q", tt.index, tt.count, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package makefile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// runTarget runs one of a component's lifecycle targets, using main.mk's override of it if there is one.
+// Failures are returned as a *TargetError.
+func (m *Makefile) runTarget(ctx context.Context, c *Component, target string, out io.Writer, env []string) (string, error) {
+	timeout := m.timeoutFor(c, target)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	inv := &Invocation{
+		Component: c,
+		Target:    target,
+		Out:       out,
+		Env:       env,
+		Make:      m.Make,
+	}
+
+	if m.isHermetic(c) {
+		inv.Env = mergeEnv(m.inherited(c), env)
+		inv.Hermetic = true
+	}
+
+	var output string
+	var err error
+
+	start := time.Now()
+
+	if m.ContainsOverride(c.Name, target) {
+		inv.Target = fmt.Sprintf("%s/%s", c.Name, target)
+
+		output, err = runMake(ctx, m.FullPath, "", nil, inv)
+	} else {
+		output, err = c.Driver.Run(ctx, inv)
+	}
+
+	if err == nil {
+		return output, nil
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%s target of %s timed out after %s", target, c.Name, timeout)
+	}
+
+	// the report names the target rather than main.mk's override of it
+	inv.Target = target
+
+	return output, newTargetError(c.Name, inv, output, time.Since(start), err)
+}
+
+// timeoutFor returns how long the component's target may run for, or 0 if there is no limit
+func (m *Makefile) timeoutFor(c *Component, target string) time.Duration {
+	if timeout, ok := c.Timeouts[target]; ok {
+		return timeout
+	}
+
+	return m.Timeouts[target]
+}
@@ -0,0 +1,204 @@
+package makefile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkAfter checks that every `#makeup: after` names a task other than the component itself, and that tasks
+// don't wait on each other in a cycle
+func checkAfter(components []*Component) error {
+	byName := map[string]*Component{}
+	for _, c := range components {
+		byName[c.Name] = c
+	}
+
+	for _, c := range components {
+		for _, name := range c.After {
+			dep, ok := byName[name]
+
+			switch {
+			case !ok:
+				return &ParseError{Pos: c.Pos, Msg: fmt.Sprintf("%s runs after unknown component %s", c.Name, name)}
+			case dep == c:
+				return &ParseError{Pos: c.Pos, Msg: fmt.Sprintf("%s can't run after itself", c.Name)}
+			case !dep.Task:
+				return &ParseError{Pos: c.Pos, Msg: fmt.Sprintf("%s runs after %s, which is not a task (add a '#makeup: task' line before it)", c.Name, name)}
+			}
+		}
+	}
+
+	// maps components to whether they are being visited (false) or have been checked (true)
+	visited := map[*Component]bool{}
+
+	var visit func(c *Component, path []string) error
+	visit = func(c *Component, path []string) error {
+		path = append(path, c.Name)
+
+		if done, seen := visited[c]; seen {
+			if done {
+				return nil
+			}
+
+			return &ParseError{Pos: c.Pos, Msg: fmt.Sprintf("tasks run after each other in a cycle: %s", strings.Join(path, " -> "))}
+		}
+
+		visited[c] = false
+
+		for _, name := range c.After {
+			if err := visit(byName[name], path); err != nil {
+				return err
+			}
+		}
+
+		visited[c] = true
+
+		return nil
+	}
+
+	for _, c := range components {
+		if err := visit(c, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// afterOrder returns the components ordered so that each comes after the ones it runs `#makeup: after`, and
+// otherwise in the order they were declared. checkAfter has made sure there are no cycles.
+func afterOrder(components []*Component) []*Component {
+	byName := map[string]*Component{}
+	for _, c := range components {
+		byName[c.Name] = c
+	}
+
+	ordered := []*Component{}
+	added := map[*Component]bool{}
+
+	var add func(c *Component)
+	add = func(c *Component) {
+		if added[c] {
+			return
+		}
+
+		added[c] = true
+
+		for _, name := range c.After {
+			// components that weren't chosen aren't waited for
+			if dep, ok := byName[name]; ok {
+				add(dep)
+			}
+		}
+
+		ordered = append(ordered, c)
+	}
+
+	for _, c := range components {
+		add(c)
+	}
+
+	return ordered
+}
+
+// taskRun is the result of running a task's run target
+type taskRun struct {
+	done     chan struct{}
+	ran      bool
+	err      error
+	duration time.Duration
+}
+
+// taskRuns tracks the tasks being run by RunAll, so that components can wait for the tasks they run after
+type taskRuns struct {
+	lock      sync.Mutex
+	tasks     []*Component
+	runs      map[string]*taskRun
+	remaining int
+}
+
+func newTaskRuns(components []*Component) *taskRuns {
+	t := &taskRuns{
+		tasks: []*Component{},
+		runs:  map[string]*taskRun{},
+	}
+
+	for _, c := range components {
+		if c.Task {
+			t.tasks = append(t.tasks, c)
+			t.runs[c.Name] = &taskRun{done: make(chan struct{})}
+		}
+	}
+
+	t.remaining = len(t.tasks)
+
+	return t
+}
+
+// wait blocks until each of the named tasks has succeeded, returning an error if any of them fail.
+// Tasks that aren't being run (because other components were chosen) are not waited for.
+func (t *taskRuns) wait(ctx context.Context, names []string) error {
+	for _, name := range names {
+		run, ok := t.runs[name]
+		if !ok {
+			continue
+		}
+
+		select {
+		case <-run.done:
+			if run.err != nil {
+				return fmt.Errorf("task %s failed", name)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// names returns the names of every task being run
+func (t *taskRuns) names() []string {
+	names := []string{}
+	for _, c := range t.tasks {
+		names = append(names, c.Name)
+	}
+
+	return names
+}
+
+// finish records a task's result, and prints a summary of every task once they have all finished
+func (t *taskRuns) finish(c *Component, ran bool, err error, duration time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	run := t.runs[c.Name]
+	run.ran, run.err, run.duration = ran, err, duration
+	close(run.done)
+
+	t.remaining--
+	if t.remaining > 0 {
+		return
+	}
+
+	fmt.Println("tasks:")
+
+	for _, task := range t.tasks {
+		fmt.Printf("  %s: %s\n", task.Name, t.runs[task.Name].status())
+	}
+}
+
+// status describes how the task's run ended
+func (r *taskRun) status() string {
+	switch {
+	case !r.ran:
+		return "not run"
+	case r.err == nil:
+		return fmt.Sprintf("exit 0 (%s)", r.duration.Round(time.Millisecond))
+	}
+
+	return fmt.Sprintf("%s (%s)", exitStatus(r.err), r.duration.Round(time.Millisecond))
+}
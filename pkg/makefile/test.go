@@ -1,43 +1,229 @@
 package makefile
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/cohix/makeup/pkg/exec"
 	"github.com/pkg/errors"
 )
 
-// TestAll sequentially runs each of the project components' test targets
-func (m *Makefile) TestAll() error {
-	cwd, err := os.Getwd()
+// TestAll runs each of the project components' test targets, running up to Jobs of them at once and
+// testing components after those they run `#makeup: after`. Failed test targets are run again up to Retries
+// times. It stops at the first failure unless KeepGoing is set, prints a table of the results, and
+// returns a result for every component (including those that weren't tested) along with any error.
+func (m *Makefile) TestAll(ctx context.Context) ([]*TestResult, error) {
+	interrupted := ctx
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := m.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	failed := &failures{}
+	tested := map[*Component]*TestResult{}
+	lock := sync.Mutex{}
+
+	done := map[string]chan struct{}{}
+	for _, c := range m.Components {
+		done[c.Name] = make(chan struct{})
+	}
+
+	slots := make(chan struct{}, jobs)
+	wg := sync.WaitGroup{}
+
+	for _, c := range afterOrder(m.Components) {
+		component := c
+
+		for _, name := range component.After {
+			if dep, ok := done[name]; ok {
+				select {
+				case <-dep:
+				case <-ctx.Done():
+				}
+			}
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer close(done[component.Name])
+			defer func() { <-slots }()
+
+			// a nil writer outputs to the terminal, and output is prefixed when components run at once
+			var out io.Writer
+			if jobs > 1 {
+				out = exec.NewPrefixWriter(component.Name, os.Stdout)
+			}
+
+			result, err := m.testComponent(ctx, component, out)
+
+			// components stopped because another failed are left out of the results
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+
+			lock.Lock()
+			tested[component] = result
+			lock.Unlock()
+
+			if err != nil {
+				failed.add(component.Name, err)
+
+				if !m.KeepGoing {
+					cancel()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	results := []*TestResult{}
+
+	for _, c := range m.Components {
+		result, ok := tested[c]
+		if !ok {
+			result = &TestResult{Component: c.Name, Status: TestSkipped}
+		}
+
+		results = append(results, result)
+	}
+
+	err := failed.summarize("test")
+
+	fmt.Println()
+	printTestTable(results)
+	printFlaky(results)
+
+	if err == nil && interrupted.Err() != nil {
+		err = errors.Wrap(interrupted.Err(), "tests were stopped")
+	}
+
+	return results, err
+}
+
+// testComponent runs the component's test target, writing its output to out, and returns its result.
+// A cached result is returned instead if nothing the tests depend on has changed since they last passed.
+func (m *Makefile) testComponent(ctx context.Context, c *Component, out io.Writer) (*TestResult, error) {
+	fmt.Println("testing:", c.Name)
+
+	result := &TestResult{Component: c.Name, Status: TestFailed}
+
+	env, err := m.targetEnv(c, "test")
 	if err != nil {
-		return errors.Wrap(err, "failed to Getwd")
+		return result, errors.Wrap(err, "failed to targetEnv")
 	}
 
-	binBase := filepath.Join(cwd, ".bin")
+	key := ""
 
-	for _, incl := range m.Includes {
-		componentDir := filepath.Dir(incl.Path)
-		componentMakefile := filepath.Base(incl.Path)
-		componentName := strings.TrimSuffix(componentMakefile, ".mk")
+	if !m.NoCache {
+		if key, err = m.testCacheKey(ctx, c, env); err != nil {
+			return result, errors.Wrap(err, "failed to testCacheKey")
+		}
 
-		fmt.Println("testing:", componentName)
+		if cached := m.cachedTestResult(c, key); cached != nil {
+			fmt.Println("test cached:", c.Name)
+			return cached, nil
+		}
+	}
 
-		binDest := filepath.Join(binBase, componentName)
+	if m.Cover {
+		if err := m.resetCoverage(c); err != nil {
+			return result, errors.Wrap(err, "failed to resetCoverage")
+		}
+	}
 
-		env := []string{
-			fmt.Sprintf("BIN_DEST=%s", binDest),
+	for attempt := 1; ; attempt++ {
+		result, err = m.runTest(ctx, c, "test", env, out)
+		result.Attempts = attempt
+
+		// only failed test targets are retried, rather than errors from makeup itself
+		var targetErr *TargetError
+		if err == nil || attempt > m.Retries || ctx.Err() != nil || !errors.As(err, &targetErr) {
+			break
 		}
 
-		if _, err := exec.RunInDir(fmt.Sprintf("make -s -f %s test", componentMakefile), componentDir, nil, env...); err != nil {
-			return errors.Wrapf(err, "failed to test %s", componentDir)
+		fmt.Printf("retrying test: %s (attempt %d of %d)\n", c.Name, attempt+1, m.Retries+1)
+	}
+
+	result.Flaky = err == nil && result.Attempts > 1
+
+	if key != "" {
+		if cacheErr := m.cacheTestResult(c, key, result); cacheErr != nil {
+			return result, errors.Wrap(cacheErr, "failed to cacheTestResult")
 		}
+	}
 
-		fmt.Println("test complete:", componentName)
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to test %s", c.Dir)
 	}
 
-	return nil
+	fmt.Println("test complete:", c.Name)
+
+	return result, nil
+}
+
+// runTest runs a target of the component that runs tests, writing its output to out, and returns its result
+func (m *Makefile) runTest(ctx context.Context, c *Component, target string, env []string, out io.Writer) (*TestResult, error) {
+	result := &TestResult{Component: c.Name, Status: TestFailed}
+
+	var events *goTestWriter
+
+	if c.TestJSON {
+		terminal := out
+		if terminal == nil {
+			terminal = os.Stdout
+		}
+
+		events = newGoTestWriter(terminal)
+		out = events
+	}
+
+	start := time.Now()
+
+	output, err := m.runTarget(ctx, c, target, out, env)
+
+	result.Duration = time.Since(start)
+	result.Output = output
+
+	if events != nil {
+		if flushErr := events.flush(); flushErr != nil {
+			return result, errors.Wrap(flushErr, "failed to flush test output")
+		}
+
+		result.Output = events.text.String()
+		result.Cases = events.testCases()
+
+		// report the test output rather than the JSON events
+		var targetErr *TargetError
+		if errors.As(err, &targetErr) {
+			targetErr.Output = tailLines(result.Output)
+		}
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	result.Status = TestPassed
+
+	return result, nil
 }
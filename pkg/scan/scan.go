@@ -0,0 +1,167 @@
+package scan
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cohix/makeup/pkg/exec"
+)
+
+// Toolchain is a language toolchain used by a component
+type Toolchain string
+
+const (
+	Go     Toolchain = "go"
+	Node   Toolchain = "node"
+	Rust   Toolchain = "rust"
+	Python Toolchain = "python"
+)
+
+// markers maps files that mark a directory as a component to that component's toolchain
+var markers = []struct {
+	file      string
+	toolchain Toolchain
+}{
+	{"package.json", Node},
+	{"Cargo.toml", Rust},
+	{"pyproject.toml", Python},
+}
+
+// skipDirs are directories that never contain components
+var skipDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+	"target":       true,
+	"testdata":     true,
+}
+
+var versionRegex = regexp.MustCompile(`\d+\.\d+`)
+
+// Candidate is a directory that looks like a component
+type Candidate struct {
+	// Dir is the candidate's directory relative to the scanned root
+	Dir       string
+	Toolchain Toolchain
+}
+
+// Detect walks the directory tree under root and returns the component candidates within it.
+// Go components are main packages, and the others are directories containing a package.json,
+// Cargo.toml or pyproject.toml (whose subdirectories are not scanned any further). A go.mod isn't
+// a marker, since a module can contain several binaries that each need their own component (or
+// only libraries, which have nothing to run), so its main packages are found instead.
+func Detect(root string) ([]Candidate, error) {
+	candidates := []Candidate{}
+
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if path != root && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_") || skipDirs[d.Name()]) {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return errors.Wrap(err, "failed to filepath.Rel")
+		}
+
+		for _, m := range markers {
+			if _, err := os.Stat(filepath.Join(path, m.file)); err == nil {
+				candidates = append(candidates, Candidate{Dir: rel, Toolchain: m.toolchain})
+				return filepath.SkipDir
+			}
+		}
+
+		isMain, err := isMainPackage(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to isMainPackage %s", path)
+		}
+
+		if isMain {
+			candidates = append(candidates, Candidate{Dir: rel, Toolchain: Go})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to WalkDir")
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Dir < candidates[j].Dir
+	})
+
+	return candidates, nil
+}
+
+// CheckCmd returns the command used to check the toolchain's version
+func (t Toolchain) CheckCmd() string {
+	switch t {
+	case Go:
+		return "go version"
+	case Node:
+		return "node --version"
+	case Rust:
+		return "cargo --version"
+	case Python:
+		return "python3 --version"
+	}
+
+	return ""
+}
+
+// Version returns the major.minor version of the installed toolchain, which is what its check compares
+// against. For Go, this isn't the go.mod `go` line, which is only the minimum version the module needs.
+func (t Toolchain) Version() (string, error) {
+	out, err := exec.RunSilent(t.CheckCmd(), "")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to RunSilent %s", t.CheckCmd())
+	}
+
+	version := versionRegex.FindString(out)
+	if version == "" {
+		return "", errors.Errorf("failed to find a version in the output of %s: %s", t.CheckCmd(), out)
+	}
+
+	return version, nil
+}
+
+// isMainPackage returns true if the directory contains a Go main package
+func isMainPackage(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to ReadDir")
+	}
+
+	fset := token.NewFileSet()
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") || strings.HasSuffix(e.Name(), "_test.go") {
+			continue
+		}
+
+		file, err := parser.ParseFile(fset, filepath.Join(dir, e.Name()), nil, parser.PackageClauseOnly)
+		if err != nil {
+			continue
+		}
+
+		if file.Name.Name == "main" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
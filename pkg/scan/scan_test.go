@@ -0,0 +1,61 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"go.mod":                    "module example.com/repo\n\ngo 1.18\n",
+		"cmd/api/main.go":           "package main\n\nfunc main() {}\n",
+		"cmd/worker/main.go":        "package main\n\nfunc main() {}\n",
+		"cmd/worker/main_test.go":   "package main\n",
+		"pkg/lib/lib.go":            "package lib\n",
+		"lib/go.mod":                "module example.com/lib\n",
+		"lib/lib.go":                "package lib\n",
+		"web/package.json":          "{}\n",
+		"web/sub/main.go":           "package main\n",
+		"web/node_modules/x/a.json": "{}\n",
+		"crates/tool/Cargo.toml":    "",
+		"vendor/x/main.go":          "package main\n",
+		".hidden/main.go":           "package main\n",
+	}
+
+	for path, contents := range files {
+		full := filepath.Join(root, path)
+
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+
+		if err := os.WriteFile(full, []byte(contents), 0644); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+
+	candidates, err := Detect(root)
+	if err != nil {
+		t.Fatalf("Detect: %s", err)
+	}
+
+	want := []Candidate{
+		{Dir: "cmd/api", Toolchain: Go},
+		{Dir: "cmd/worker", Toolchain: Go},
+		{Dir: "crates/tool", Toolchain: Rust},
+		{Dir: "web", Toolchain: Node},
+	}
+
+	if len(candidates) != len(want) {
+		t.Fatalf("got %v, want %v", candidates, want)
+	}
+
+	for i := range want {
+		if candidates[i] != want[i] {
+			t.Errorf("got %v, want %v", candidates[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Default is the template used when none is specified
+const Default = "go"
+
+const templateExt = ".mk.tmpl"
+
+//go:embed builtin/*.mk.tmpl
+var builtin embed.FS
+
+// Vars are the variables available to a component template
+type Vars struct {
+	// Name is the component's name
+	Name string
+	// Dir is the component's directory relative to the project root
+	Dir string
+	// MkPath is the component's .mk file relative to the project root
+	MkPath string
+}
+
+// Dirs returns the directories that user templates are discovered from, in order of priority
+func Dirs(projectRoot string) []string {
+	dirs := []string{filepath.Join(projectRoot, ".makeup", "templates")}
+
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configDir = filepath.Join(home, ".config")
+		}
+	}
+
+	if configDir != "" {
+		dirs = append(dirs, filepath.Join(configDir, "makeup", "templates"))
+	}
+
+	return dirs
+}
+
+// Render renders the named template with the given vars. User templates (`<name>.mk.tmpl`) from
+// dirs take priority over the built-in templates.
+func Render(name string, dirs []string, vars Vars) ([]byte, error) {
+	text, err := find(name, dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to Parse template %s", name)
+	}
+
+	buf := bytes.Buffer{}
+
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, errors.Wrapf(err, "failed to Execute template %s", name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Names returns the names of every available template
+func Names(dirs []string) []string {
+	seen := map[string]bool{}
+
+	builtins, _ := builtin.ReadDir("builtin")
+	for _, e := range builtins {
+		seen[strings.TrimSuffix(e.Name(), templateExt)] = true
+	}
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, e := range entries {
+			if !e.IsDir() && strings.HasSuffix(e.Name(), templateExt) {
+				seen[strings.TrimSuffix(e.Name(), templateExt)] = true
+			}
+		}
+	}
+
+	names := []string{}
+	for n := range seen {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// find returns the text of the named template
+func find(name string, dirs []string) (string, error) {
+	filename := name + templateExt
+
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(dir, filename))
+		if err == nil {
+			return string(data), nil
+		}
+
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", errors.Wrapf(err, "failed to ReadFile %s", filepath.Join(dir, filename))
+		}
+	}
+
+	data, err := builtin.ReadFile("builtin/" + filename)
+	if err != nil {
+		return "", fmt.Errorf("unknown template %s (available: %s)", name, strings.Join(Names(dirs), ", "))
+	}
+
+	return string(data), nil
+}